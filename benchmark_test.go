@@ -2,7 +2,9 @@ package flatrtree
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"runtime"
 	"testing"
 	"time"
 
@@ -180,3 +182,216 @@ func Benchmark_NeighborsGeodetic(b *testing.B) {
 		}
 	}
 }
+
+func Benchmark_DeserializeSearch(b *testing.B) {
+	// California-ish
+	minX := -124.628906
+	minY := 32.509762
+	maxX := -113.818359
+	maxY := 42.261049
+	iterf := func(int64) bool { return true }
+
+	for builderName, newBuilder := range testBuilders {
+		builder := newBuilder()
+		for ref, city := range cities {
+			builder.Add(int64(ref), city.Lon, city.Lat, city.Lon, city.Lat)
+		}
+
+		rtree, err := builder.Finish(DefaultDegree)
+		require.Nil(b, err)
+
+		data, err := Serialize(rtree, 5)
+		require.Nil(b, err)
+
+		b.Run(fmt.Sprintf("%v/Eager", builderName), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index, _ := Deserialize(data)
+				index.Search(minX, minY, maxX, maxY, iterf)
+			}
+		})
+
+		b.Run(fmt.Sprintf("%v/Lazy", builderName), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index, _ := DeserializeLazy(data)
+				index.Search(minX, minY, maxX, maxY, iterf)
+			}
+		})
+	}
+}
+
+// leafOverlap sums the areas where boxes of sibling
+// leaf nodes intersect, a proxy for wasted search work.
+func leafOverlap(index *RTree) float64 {
+	var overlap float64
+	for refIdx := index.count; refIdx < len(index.refs)-1; refIdx++ {
+		start, end := index.refs[refIdx], index.refs[refIdx+1]
+
+		// only nodes whose children are leaf nodes
+		child := start / 4
+		if child < int64(index.count) || index.refs[child] >= int64(4*index.count) {
+			continue
+		}
+		for i := start; i < end; i += 4 {
+			for j := i + 4; j < end; j += 4 {
+				w := math.Min(index.boxes[i+2], index.boxes[j+2]) - math.Max(index.boxes[i], index.boxes[j])
+				h := math.Min(index.boxes[i+3], index.boxes[j+3]) - math.Max(index.boxes[i+1], index.boxes[j+1])
+				if w > 0 && h > 0 {
+					overlap += w * h
+				}
+			}
+		}
+	}
+	return overlap
+}
+
+func Benchmark_HilbertBits(b *testing.B) {
+	// a dense city of small boxes in a world extent
+	rng := rand.New(rand.NewSource(1))
+	boxes := []float64{-180, -90, -180, -90, 180, 90, 180, 90}
+	for i := 0; i < 200000; i++ {
+		x := -122.5 + rng.Float64()*0.1
+		y := 37.7 + rng.Float64()*0.1
+		boxes = append(boxes, x, y, x+0.0001, y+0.0001)
+	}
+
+	for _, bits := range []int{16, 32} {
+		b.Run(fmt.Sprintf("bits=%d", bits), func(b *testing.B) {
+			var overlap float64
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				builder := NewHilbertBuilderWithOptions(HilbertOptions{Bits: bits})
+				for j := 0; j < len(boxes); j += 4 {
+					builder.Add(int64(j/4), boxes[j], boxes[j+1], boxes[j+2], boxes[j+3])
+				}
+				index, err := builder.Finish(DefaultDegree)
+				require.Nil(b, err)
+				overlap = leafOverlap(index)
+			}
+			b.ReportMetric(overlap, "overlap")
+		})
+	}
+}
+
+func Benchmark_KNN(b *testing.B) {
+	builder := NewHilbertBuilder()
+	for ref, city := range cities {
+		builder.Add(int64(ref), city.Lon, city.Lat, city.Lon, city.Lat)
+	}
+
+	rtree, err := builder.Finish(DefaultDegree)
+	require.Nil(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		city := cities[i%len(cities)]
+		rtree.KNN(city.Lon, city.Lat, 10, math.Inf(1), GeodeticBoxDist, nil)
+	}
+}
+
+func Benchmark_Join(b *testing.B) {
+	builder := NewHilbertBuilder()
+	for ref, city := range cities {
+		builder.Add(int64(ref), city.Lon, city.Lat, city.Lon, city.Lat)
+	}
+	points, err := builder.Finish(DefaultDegree)
+	require.Nil(b, err)
+
+	// one degree boxes around the first thousand cities
+	areaBoxes := make([]float64, 0, 4000)
+	builder = NewHilbertBuilder()
+	for ref, city := range cities[:1000] {
+		builder.Add(int64(ref), city.Lon-0.5, city.Lat-0.5, city.Lon+0.5, city.Lat+0.5)
+		areaBoxes = append(areaBoxes, city.Lon-0.5, city.Lat-0.5, city.Lon+0.5, city.Lat+0.5)
+	}
+	areas, err := builder.Finish(DefaultDegree)
+	require.Nil(b, err)
+
+	iterf := func(refA, refB int64) bool { return true }
+
+	b.Run("Join", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			Join(areas, points, iterf)
+		}
+	})
+
+	b.Run("Search", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < len(areaBoxes); j += 4 {
+				points.Search(areaBoxes[j], areaBoxes[j+1], areaBoxes[j+2], areaBoxes[j+3], func(ref int64) bool {
+					return iterf(int64(j/4), ref)
+				})
+			}
+		}
+	})
+}
+
+func Benchmark_BuildParallel(b *testing.B) {
+	builders := map[string]func(parallelism int) Builder{
+		"Hilbert": func(parallelism int) Builder {
+			return NewHilbertBuilderWithOptions(HilbertOptions{Parallelism: parallelism})
+		},
+		"OMT": func(parallelism int) Builder {
+			return NewOMTBuilderWithOptions(OMTOptions{Parallelism: parallelism})
+		},
+	}
+
+	parallelisms := []int{1, 2, 4}
+	if n := runtime.NumCPU(); n > 4 {
+		parallelisms = append(parallelisms, n)
+	}
+
+	for builderName, newBuilder := range builders {
+		for _, parallelism := range parallelisms {
+			b.Run(fmt.Sprintf("%v/parallelism=%d", builderName, parallelism), func(b *testing.B) {
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					builder := newBuilder(parallelism)
+					for i, city := range cities {
+						builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+					}
+					builder.Finish(DefaultDegree)
+				}
+			})
+		}
+	}
+}
+
+func Benchmark_BuildReset(b *testing.B) {
+	const tileSize = 256
+
+	for name, newBuilder := range optionsBuilders {
+		b.Run(fmt.Sprintf("%v/new", name), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				builder := newBuilder()
+				for j, city := range cities[:tileSize] {
+					builder.Add(int64(j), city.Lon, city.Lat, city.Lon, city.Lat)
+				}
+				builder.Finish(DefaultDegree)
+			}
+		})
+
+		b.Run(fmt.Sprintf("%v/reset", name), func(b *testing.B) {
+			builder := newBuilder()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				builder.Reset()
+				builder.Grow(tileSize)
+				for j, city := range cities[:tileSize] {
+					builder.Add(int64(j), city.Lon, city.Lat, city.Lon, city.Lat)
+				}
+				builder.Finish(DefaultDegree)
+			}
+		})
+	}
+}
@@ -29,6 +29,25 @@ func PlanarBoxDist(pX, pY, minX, minY, maxX, maxY float64) float64 {
 	return dX*dX + dY*dY
 }
 
+// PlanarRectRectDist returns the squared distance between two rects
+func PlanarRectRectDist(aMinX, aMinY, aMaxX, aMaxY, bMinX, bMinY, bMaxX, bMaxY float64) float64 {
+	var dX, dY float64
+
+	if aMaxX < bMinX {
+		dX = bMinX - aMaxX
+	} else if bMaxX < aMinX {
+		dX = aMinX - bMaxX
+	}
+
+	if aMaxY < bMinY {
+		dY = bMinY - aMaxY
+	} else if bMaxY < aMinY {
+		dY = aMinY - bMaxY
+	}
+
+	return dX*dX + dY*dY
+}
+
 // GeodeticBoxDist returns the distance in meters between the point and rect
 func GeodeticBoxDist(pLon, pLat, minLon, minLat, maxLon, maxLat float64) (meters float64) {
 	return earthRadiusMeters * pointRectDistGeodeticRad(
@@ -38,6 +57,55 @@ func GeodeticBoxDist(pLon, pLat, minLon, minLat, maxLon, maxLat float64) (meters
 	)
 }
 
+// GeodeticRectRectDist returns the distance in meters between two rects
+func GeodeticRectRectDist(aMinLon, aMinLat, aMaxLon, aMaxLat, bMinLon, bMinLat, bMaxLon, bMaxLat float64) (meters float64) {
+	return earthRadiusMeters * rectRectDistGeodeticRad(
+		aMinLat*math.Pi/180, aMinLon*math.Pi/180,
+		aMaxLat*math.Pi/180, aMaxLon*math.Pi/180,
+		bMinLat*math.Pi/180, bMinLon*math.Pi/180,
+		bMaxLat*math.Pi/180, bMaxLon*math.Pi/180,
+	)
+}
+
+// rectRectDistGeodeticRad extends pointRectDistGeodeticRad to a pair of
+// rects on the unit sphere.
+//
+// If the rects share a meridian, any path between them crosses at least
+// the latitude gap, which that meridian achieves. Otherwise the closest
+// point of a rect to a point outside its meridians is a corner or lies
+// on a meridian edge, so the closest pair includes a corner of one rect,
+// or lies on two meridian edges, where the distance only shrinks towards
+// the corners. Either way the minimum over the corners of each rect
+// against the other rect is exact.
+func rectRectDistGeodeticRad(φal, λal, φah, λah, φbl, λbl, φbh, λbh float64) float64 {
+	const twoΠ = 2 * math.Pi
+
+	if lonOverlaps(λal, λah, λbl, λbh) ||
+		lonOverlaps(λal, λah, λbl-twoΠ, λbh-twoΠ) ||
+		lonOverlaps(λal, λah, λbl+twoΠ, λbh+twoΠ) {
+		if φah < φbl {
+			return φbl - φah // South
+		}
+		if φbh < φal {
+			return φal - φbh // North
+		}
+		return 0 // Overlapping
+	}
+
+	dist := math.Inf(1)
+	for _, corner := range [4][2]float64{{φal, λal}, {φal, λah}, {φah, λal}, {φah, λah}} {
+		dist = math.Min(dist, pointRectDistGeodeticRad(corner[0], corner[1], φbl, λbl, φbh, λbh))
+	}
+	for _, corner := range [4][2]float64{{φbl, λbl}, {φbl, λbh}, {φbh, λbl}, {φbh, λbh}} {
+		dist = math.Min(dist, pointRectDistGeodeticRad(corner[0], corner[1], φal, λal, φah, λah))
+	}
+	return dist
+}
+
+func lonOverlaps(λal, λah, λbl, λbh float64) bool {
+	return λal <= λbh && λbl <= λah
+}
+
 /* Copyright (c) 2016 Josh Baker
 
 Permission is hereby granted, free of charge, to any person obtaining a copy
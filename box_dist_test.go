@@ -150,3 +150,85 @@ func TestPlanarBoxDist(t *testing.T) {
 	// top right
 	require.Equal(t, 2.0, PlanarBoxDist(minX-1, maxY+1, minX, minY, maxX, maxY))
 }
+
+func TestPlanarRectRectDist(t *testing.T) {
+	require.Equal(t, 0.0, PlanarRectRectDist(0, 0, 2, 2, 1, 1, 3, 3))
+	require.Equal(t, 0.0, PlanarRectRectDist(0, 0, 2, 2, 2, 2, 3, 3))
+	require.Equal(t, 1.0, PlanarRectRectDist(0, 0, 2, 2, 3, 0, 4, 2))
+	require.Equal(t, 1.0, PlanarRectRectDist(3, 0, 4, 2, 0, 0, 2, 2))
+	require.Equal(t, 4.0, PlanarRectRectDist(0, 0, 2, 2, 1, 4, 3, 5))
+	require.Equal(t, 2.0, PlanarRectRectDist(0, 0, 1, 1, 2, 2, 3, 3))
+	require.Equal(t, PlanarBoxDist(5, 7, 0, 0, 1, 1), PlanarRectRectDist(5, 7, 5, 7, 0, 0, 1, 1))
+}
+
+// sampledRectRectDist approximates the distance between two rects from
+// n points along each edge, which bounds the exact distance from above.
+func sampledRectRectDist(a, b [4]float64, n int) float64 {
+	boundary := func(r [4]float64) [][2]float64 {
+		var points [][2]float64
+		for i := 0; i <= n; i++ {
+			t := float64(i) / float64(n)
+			lon := r[0] + t*(r[2]-r[0])
+			lat := r[1] + t*(r[3]-r[1])
+			points = append(points,
+				[2]float64{lon, r[1]}, [2]float64{lon, r[3]},
+				[2]float64{r[0], lat}, [2]float64{r[2], lat},
+			)
+		}
+		return points
+	}
+
+	dist := math.Inf(1)
+	for _, p := range boundary(a) {
+		for _, q := range boundary(b) {
+			dist = math.Min(dist, haversine(p, q))
+		}
+	}
+	return dist
+}
+
+func TestGeodeticRectRectDist(t *testing.T) {
+	bbox := [4]float64{bboxMin[0], bboxMin[1], bboxMax[0], bboxMax[1]}
+
+	for _, tc := range []struct {
+		name string
+		a, b [4]float64
+	}{
+		{"north", bbox, [4]float64{-73.8, 45.8, -73.5, 45.9}},
+		{"east", bbox, [4]float64{-72.9, 45.4, -72.8, 45.5}},
+		{"east, taller", bbox, [4]float64{-72.9, 44, -72.8, 47}},
+		{"northeast", bbox, [4]float64{-72.9, 45.8, -72.8, 45.9}},
+		{"southwest", bbox, [4]float64{-75, 44, -74.5, 45}},
+		{"high latitude", [4]float64{0, 60, 10, 70}, [4]float64{150, 60, 160, 80}},
+		{"opposite meridians", [4]float64{0, 60, 10, 70}, [4]float64{170, 60, 180, 70}},
+		{"southern", [4]float64{20, -50, 30, -40}, [4]float64{35, -70, 40, -60}},
+		{"antimeridian", [4]float64{175, 10, 180, 20}, [4]float64{-180, 25, -175, 30}},
+		{"across antimeridian", [4]float64{170, 10, 179, 20}, [4]float64{-179, 15, -170, 30}},
+	} {
+		actual := GeodeticRectRectDist(tc.a[0], tc.a[1], tc.a[2], tc.a[3], tc.b[0], tc.b[1], tc.b[2], tc.b[3])
+		sampled := sampledRectRectDist(tc.a, tc.b, 200)
+		require.LessOrEqual(t, actual, sampled+epsilon, tc.name)
+		require.InDelta(t, sampled, actual, sampled*1e-3+epsilon, tc.name)
+
+		swapped := GeodeticRectRectDist(tc.b[0], tc.b[1], tc.b[2], tc.b[3], tc.a[0], tc.a[1], tc.a[2], tc.a[3])
+		require.InDelta(t, actual, swapped, epsilon, tc.name)
+	}
+}
+
+func TestGeodeticRectRectDistOverlap(t *testing.T) {
+	require.Equal(t, 0.0, GeodeticRectRectDist(0, 0, 10, 10, 5, 5, 15, 15))
+	require.Equal(t, 0.0, GeodeticRectRectDist(0, 0, 10, 10, 10, 10, 15, 15))
+	require.Equal(t, 0.0, GeodeticRectRectDist(170, 0, 180, 10, -180, 5, -170, 15))
+
+	// sharing a meridian, the distance is the latitude gap
+	expected := haversine([2]float64{5, 10}, [2]float64{5, 20})
+	require.InDelta(t, expected, GeodeticRectRectDist(0, 0, 10, 10, 5, 20, 15, 30), epsilon)
+}
+
+func TestGeodeticRectRectDistPoint(t *testing.T) {
+	for _, p := range [][2]float64{inside, north, northEast, east, southEast, south, southWest, west, northWest} {
+		expected := GeodeticBoxDist(p[0], p[1], bboxMin[0], bboxMin[1], bboxMax[0], bboxMax[1])
+		actual := GeodeticRectRectDist(p[0], p[1], p[0], p[1], bboxMin[0], bboxMin[1], bboxMax[0], bboxMax[1])
+		require.InDelta(t, expected, actual, epsilon)
+	}
+}
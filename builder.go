@@ -1,8 +1,177 @@
 package flatrtree
 
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
 const DefaultDegree int = 10
 
 type Builder interface {
 	Add(ref int64, minX, minY, maxX, maxY float64)
 	Finish(degree int) (*RTree, error)
 }
+
+// Errors returned by the builders. Use errors.Is to check which one
+// occurred. Like the errors of earlier releases, builder errors are not
+// prefixed with the package name.
+var (
+	// ErrInvalidDegree means a degree is less than 2
+	ErrInvalidDegree = errors.New("degree < 2")
+	// ErrFinishCalledTwice means Finish was called again on a builder
+	// whose buffers were already used by the previous index
+	ErrFinishCalledTwice = errors.New("Finish called more than once")
+	// ErrInvalidBox means a box has a NaN or infinite coordinate, or
+	// a min coordinate greater than its max, and is wrapped in an
+	// *InvalidBoxError in strict mode
+	ErrInvalidBox = errors.New("invalid box")
+	// ErrInvalidBits means a Hilbert curve resolution is not 16 or 32
+	ErrInvalidBits = errors.New("invalid Hilbert bits")
+	// ErrTooManyItems means a builder holds more items than the
+	// serialized format can count
+	ErrTooManyItems = errors.New("too many items")
+)
+
+// InvalidBoxError lists the refs of the invalid boxes found by Finish in
+// strict mode, or by ExternalHilbertBuilder.FinishTo.
+type InvalidBoxError struct {
+	// Refs are the refs of the invalid boxes, in the order they were added
+	Refs []int64
+	// Omitted is the number of further invalid boxes whose refs are not
+	// in Refs. ExternalHilbertBuilder keeps only the first refs found.
+	Omitted int
+}
+
+// maxInvalidBoxRefs is the most refs listed in InvalidBoxError.Error
+const maxInvalidBoxRefs = 10
+
+func (e *InvalidBoxError) Error() string {
+	more := e.Omitted
+	refs := e.Refs
+	if len(refs) > maxInvalidBoxRefs {
+		more += len(refs) - maxInvalidBoxRefs
+		refs = refs[:maxInvalidBoxRefs]
+	}
+
+	if more > 0 {
+		return fmt.Sprintf("%v for refs %v and %d more", ErrInvalidBox, refs, more)
+	}
+	return fmt.Sprintf("%v for refs %v", ErrInvalidBox, refs)
+}
+
+func (e *InvalidBoxError) Unwrap() error {
+	return ErrInvalidBox
+}
+
+// checkHilbertBits returns an error wrapping ErrInvalidBits
+// unless bits is a supported Hilbert curve resolution.
+func checkHilbertBits(bits int) error {
+	if bits != 16 && bits != 32 {
+		return fmt.Errorf("%w %d", ErrInvalidBits, bits)
+	}
+	return nil
+}
+
+// checkCount returns an error wrapping ErrTooManyItems if count
+// does not fit the uint32 count of the serialized format.
+func checkCount(count int) error {
+	if uint64(count) > math.MaxUint32 {
+		return fmt.Errorf("%w %d", ErrTooManyItems, count)
+	}
+	return nil
+}
+
+// checkBoxes returns an *InvalidBoxError listing the refs of invalid boxes
+func checkBoxes(refs []int64, boxes []float64) error {
+	var invalid []int64
+	for i, ref := range refs {
+		box := boxes[i*4 : i*4+4]
+		if !validBox(box[0], box[1], box[2], box[3]) {
+			invalid = append(invalid, ref)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return &InvalidBoxError{Refs: invalid}
+	}
+	return nil
+}
+
+func validBox(minX, minY, maxX, maxY float64) bool {
+	for _, coord := range [4]float64{minX, minY, maxX, maxY} {
+		if math.IsNaN(coord) || math.IsInf(coord, 0) {
+			return false
+		}
+	}
+	return minX <= maxX && minY <= maxY
+}
+
+// BuildOptions configures FinishWithOptions on the builders.
+type BuildOptions struct {
+	// LeafDegree is the maximum number of items in a leaf node.
+	// Defaults to DefaultDegree.
+	LeafDegree int
+
+	// NodeDegree is the maximum number of children of the nodes above
+	// the leaves. Defaults to DefaultDegree.
+	NodeDegree int
+
+	// Strict makes Finish return an *InvalidBoxError if any box has a
+	// NaN or infinite coordinate, or a min coordinate greater than its
+	// max. Such boxes are otherwise indexed as given, where they may
+	// never be found and NaN coordinates can disorder other items.
+	Strict bool
+}
+
+func (o BuildOptions) withDefaults() (BuildOptions, error) {
+	if o.LeafDegree == 0 {
+		o.LeafDegree = DefaultDegree
+	}
+	if o.NodeDegree == 0 {
+		o.NodeDegree = DefaultDegree
+	}
+	if o.LeafDegree < 2 || o.NodeDegree < 2 {
+		return o, ErrInvalidDegree
+	}
+	return o, nil
+}
+
+// numBoxes returns the number of items and nodes in a packed tree of
+// count items, where every node except the last on each level is full.
+func (o BuildOptions) numBoxes(count int) int {
+	numBoxes, n := count, count
+	for level := 0; ; level++ {
+		degree := o.degree(level)
+		n = (n + degree - 1) / degree
+		numBoxes += n
+		if n <= 1 {
+			return numBoxes
+		}
+	}
+}
+
+// degree returns the maximum number of children of
+// nodes on the given level, where leaves are level 0.
+func (o BuildOptions) degree(level int) int {
+	if level == 0 {
+		return o.LeafDegree
+	}
+	return o.NodeDegree
+}
+
+// growSlice returns s with capacity for n more elements
+func growSlice[T any](s []T, n int) []T {
+	if cap(s)-len(s) >= n {
+		return s
+	}
+	return append(make([]T, 0, len(s)+n), s...)
+}
+
+// reuseSlice returns a slice of length n, reusing s if it is large enough
+func reuseSlice[T any](s []T, n int) []T {
+	if cap(s) >= n {
+		return s[:n]
+	}
+	return make([]T, n)
+}
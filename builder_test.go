@@ -1,6 +1,9 @@
 package flatrtree
 
 import (
+	"math"
+	"math/rand"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -15,6 +18,7 @@ func TestInvalidDegree(t *testing.T) {
 				index, err := builder.Finish(invalidDegree)
 				require.Nil(t, index)
 				require.NotNil(t, err)
+				require.ErrorIs(t, err, ErrInvalidDegree)
 			})
 		}
 	}
@@ -34,10 +38,37 @@ func TestFinishAgain(t *testing.T) {
 			require.Nil(t, index)
 			require.NotNil(t, err)
 			require.Contains(t, err.Error(), "called more than once")
+			require.ErrorIs(t, err, ErrFinishCalledTwice)
 		})
 	}
 }
 
+func TestTooManyItems(t *testing.T) {
+	if strconv.IntSize < 64 {
+		t.Skip("count cannot exceed MaxUint32")
+	}
+	count := int(uint64(math.MaxUint32) + 1)
+
+	hilbertBuilder := NewHilbertBuilder()
+	hilbertBuilder.count = count
+	omtBuilder := NewOMTBuilder()
+	omtBuilder.count = count
+	strBuilder := NewSTRBuilder()
+	strBuilder.count = count
+
+	for name, builder := range map[string]Builder{
+		"Hilbert": hilbertBuilder,
+		"OMT":     omtBuilder,
+		"STR":     strBuilder,
+	} {
+		_, err := builder.Finish(DefaultDegree)
+		require.ErrorIs(t, err, ErrTooManyItems, name)
+	}
+
+	_, err := Serialize(&RTree{count: count}, 0)
+	require.ErrorIs(t, err, ErrTooManyItems)
+}
+
 func TestHilbertFunction(t *testing.T) {
 	// min and max pairs
 	require.Equal(t, uint32(0), hilbert(0, 0))
@@ -67,3 +98,328 @@ func TestHilbertFunction(t *testing.T) {
 	require.Equal(t, uint32(534213004), hilbert(18084, 14710))
 	require.Equal(t, uint32(1194905159), hilbert(11890, 39641))
 }
+
+func TestSTRGrouping(t *testing.T) {
+	// a 4x4 grid of points is cut into two vertical slices, each cut
+	// into two nodes, then the nodes are sorted by x and y at the root
+	builder := NewSTRBuilder()
+	for i := 0; i < 16; i++ {
+		x, y := float64(i%4), float64(i/4)
+		builder.Add(int64(i), x, y, x, y)
+	}
+
+	index, err := builder.Finish(4)
+	require.Nil(t, err)
+	require.Nil(t, index.Validate())
+
+	require.Equal(t, []float64{
+		0, 0, 1, 1,
+		2, 0, 3, 1,
+		0, 2, 1, 3,
+		2, 2, 3, 3,
+		0, 0, 3, 3,
+	}, index.boxes[16*4:])
+	require.Equal(t, []int64{0, 16, 32, 48, 64, 80}, index.refs[16:])
+	require.Equal(t, []int64{0, 1, 4, 5}, index.refs[:4])
+}
+
+func TestOMTHeight(t *testing.T) {
+	// Some counts that are powers of the degree keep the extra level
+	// that earlier releases built for them.
+	for _, tc := range []struct{ count, degree, numRefs int }{
+		{9, 3, 15},
+		{125, 5, 158},
+		{100, 10, 112},
+	} {
+		builder := NewOMTBuilder()
+		for i := 0; i < tc.count; i++ {
+			builder.Add(int64(i), float64(i), float64(i), float64(i), float64(i))
+		}
+		index, err := builder.Finish(tc.degree)
+		require.Nil(t, err)
+		require.Nil(t, index.Validate())
+		require.Len(t, index.refs, tc.numRefs, "count %d, degree %d", tc.count, tc.degree)
+	}
+}
+
+func TestHilbert64Function(t *testing.T) {
+	require.Equal(t, uint64(0), hilbert64(0, 0))
+	require.Equal(t, uint64(1), hilbert64(1, 0))
+	require.Equal(t, uint64(2), hilbert64(1, 1))
+	require.Equal(t, uint64(3), hilbert64(0, 1))
+	require.Equal(t, uint64(math.MaxUint64), hilbert64(math.MaxUint32, 0))
+
+	// the 16-bit curve is a coarser version of the 32-bit curve
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		x, y := uint32(rng.Intn(1<<16)), uint32(rng.Intn(1<<16))
+		require.Equal(t, uint64(hilbert(x, y)), hilbert64(x<<16, y<<16)>>32)
+		require.Equal(t, uint64(hilbert(x, y)), hilbert64(x<<16|uint32(rng.Intn(1<<16)), y<<16|uint32(rng.Intn(1<<16)))>>32)
+	}
+}
+
+func TestHilbertBits(t *testing.T) {
+	builder := NewHilbertBuilderWithOptions(HilbertOptions{Bits: 24})
+	builder.Add(0, 1, 1, 2, 2)
+	_, err := builder.Finish(DefaultDegree)
+	require.ErrorIs(t, err, ErrInvalidBits)
+
+	// items closer than a 16-bit cell are still ordered along the curve
+	builder = NewHilbertBuilderWithOptions(HilbertOptions{Bits: 32})
+	builder.Add(0, 0, 0, 0, 0)
+	builder.Add(1, 1, 1, 1, 1)
+	for i := 0; i < 4; i++ {
+		x, y := 0.5+float64(i%2)*1e-6, 0.5+float64(i/2)*1e-6
+		builder.Add(int64(i+2), x, y, x, y)
+	}
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+	require.Nil(t, index.Validate())
+	require.Equal(t, []int64{0, 2, 4, 5, 3, 1}, index.refs[:6])
+}
+
+func TestParallelBuild(t *testing.T) {
+	builders := map[string]func(parallelism int) Builder{
+		"Hilbert": func(parallelism int) Builder {
+			return NewHilbertBuilderWithOptions(HilbertOptions{Parallelism: parallelism})
+		},
+		"Hilbert32": func(parallelism int) Builder {
+			return NewHilbertBuilderWithOptions(HilbertOptions{Bits: 32, Parallelism: parallelism})
+		},
+		"OMT": func(parallelism int) Builder {
+			return NewOMTBuilderWithOptions(OMTOptions{Parallelism: parallelism})
+		},
+	}
+
+	build := func(newBuilder func(int) Builder, parallelism int) []byte {
+		builder := newBuilder(parallelism)
+		for i, city := range cities {
+			builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+		}
+		// duplicates share Hilbert values
+		for i, city := range cities[:minParallelItems] {
+			builder.Add(int64(len(cities)+i), city.Lon, city.Lat, city.Lon, city.Lat)
+		}
+
+		index, err := builder.Finish(DefaultDegree)
+		require.Nil(t, err)
+		require.Nil(t, index.Validate())
+
+		data, err := Serialize(index, 7)
+		require.Nil(t, err)
+		return data
+	}
+
+	for name, newBuilder := range builders {
+		t.Run(name, func(t *testing.T) {
+			expected := build(newBuilder, 2)
+			for _, parallelism := range []int{3, 8} {
+				require.Equal(t, expected, build(newBuilder, parallelism), "parallelism %d", parallelism)
+			}
+
+			// a serial Hilbert build can order ties differently
+			if name == "OMT" {
+				require.Equal(t, expected, build(newBuilder, 0))
+			}
+		})
+	}
+}
+
+// optionsBuilder is implemented by the in-memory builders
+type optionsBuilder interface {
+	Builder
+	FinishWithOptions(opts BuildOptions) (*RTree, error)
+	AddBoxes(refs []int64, boxes []float64)
+	Grow(n int)
+	Reset()
+}
+
+var optionsBuilders = map[string]func() optionsBuilder{
+	"Hilbert": func() optionsBuilder { return NewHilbertBuilder() },
+	"OMT":     func() optionsBuilder { return NewOMTBuilder() },
+	"STR":     func() optionsBuilder { return NewSTRBuilder() },
+}
+
+func TestFinishWithOptions(t *testing.T) {
+	_, items := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	for name, newBuilder := range optionsBuilders {
+		t.Run(name, func(t *testing.T) {
+			for _, opts := range []BuildOptions{
+				{LeafDegree: 64, NodeDegree: 4},
+				{LeafDegree: 3, NodeDegree: 16},
+				{LeafDegree: 2, NodeDegree: 2},
+				{LeafDegree: 16},
+			} {
+				for _, count := range []int{1, 2, 3, 64, 100} {
+					builder := newBuilder()
+					for i := 0; i < count; i++ {
+						builder.Add(int64(i), items[i*4], items[i*4+1], items[i*4+2], items[i*4+3])
+					}
+					index, err := builder.FinishWithOptions(opts)
+					require.Nil(t, err)
+					require.Nil(t, index.Validate())
+
+					opts, _ := opts.withDefaults()
+					for refIdx := index.count; refIdx < len(index.refs)-1; refIdx++ {
+						children := int(index.refs[refIdx+1]-index.refs[refIdx]) / 4
+						if int(index.refs[refIdx])/4 < index.count {
+							require.LessOrEqual(t, children, opts.LeafDegree)
+						} else {
+							require.LessOrEqual(t, children, opts.NodeDegree)
+						}
+					}
+
+					var found int
+					index.Search(math.Inf(-1), math.Inf(-1), math.Inf(1), math.Inf(1), func(int64) bool {
+						found++
+						return true
+					})
+					require.Equal(t, count, found)
+				}
+			}
+
+			// Finish uses the same degree on every level
+			expected, _ := createIndex(t, func() Builder { return newBuilder() }, 100, 5)
+			builder := newBuilder()
+			for i := 0; i < 100; i++ {
+				builder.Add(int64(i), items[i*4], items[i*4+1], items[i*4+2], items[i*4+3])
+			}
+			actual, err := builder.FinishWithOptions(BuildOptions{LeafDegree: 5, NodeDegree: 5})
+			require.Nil(t, err)
+			require.Equal(t, expected, actual)
+
+			_, err = newBuilder().FinishWithOptions(BuildOptions{LeafDegree: 1})
+			require.ErrorIs(t, err, ErrInvalidDegree)
+			_, err = newBuilder().FinishWithOptions(BuildOptions{NodeDegree: -1})
+			require.ErrorIs(t, err, ErrInvalidDegree)
+		})
+	}
+}
+
+func TestStrict(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+
+	for name, newBuilder := range optionsBuilders {
+		t.Run(name, func(t *testing.T) {
+			add := func(builder Builder) {
+				builder.Add(0, 0, 0, 1, 1)
+				builder.Add(1, nan, 0, 1, 1)
+				builder.Add(2, 0, 0, 1, 1)
+				builder.Add(3, 0, -inf, 1, 1)
+				builder.Add(4, 2, 0, 1, 1) // minX > maxX
+				builder.Add(5, 0, 0, 1, inf)
+				builder.Add(6, 1, 1, 1, 1)
+				builder.Add(7, 0, 1, 1, 0) // minY > maxY
+			}
+
+			builder := newBuilder()
+			add(builder)
+			index, err := builder.FinishWithOptions(BuildOptions{Strict: true})
+			require.Nil(t, index)
+			require.ErrorIs(t, err, ErrInvalidBox)
+
+			var boxErr *InvalidBoxError
+			require.ErrorAs(t, err, &boxErr)
+			require.Equal(t, []int64{1, 3, 4, 5, 7}, boxErr.Refs)
+			require.EqualError(t, err, "invalid box for refs [1 3 4 5 7]")
+
+			// not strict
+			builder = newBuilder()
+			add(builder)
+			index, err = builder.FinishWithOptions(BuildOptions{})
+			require.Nil(t, err)
+			require.Equal(t, 8, index.Count())
+
+			// valid boxes
+			builder = newBuilder()
+			builder.Add(0, 0, 0, 1, 1)
+			builder.Add(1, 1, 1, 1, 1)
+			index, err = builder.FinishWithOptions(BuildOptions{Strict: true})
+			require.Nil(t, err)
+			require.Equal(t, 2, index.Count())
+		})
+	}
+}
+
+func TestInvalidBoxErrorMessage(t *testing.T) {
+	refs := make([]int64, 15)
+	for i := range refs {
+		refs[i] = int64(i)
+	}
+	err := &InvalidBoxError{Refs: refs}
+	require.EqualError(t, err, "invalid box for refs [0 1 2 3 4 5 6 7 8 9] and 5 more")
+
+	err = &InvalidBoxError{Refs: refs[:3], Omitted: 4}
+	require.EqualError(t, err, "invalid box for refs [0 1 2] and 4 more")
+}
+
+func TestReset(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for name, newBuilder := range optionsBuilders {
+		t.Run(name, func(t *testing.T) {
+			builder := newBuilder()
+			for _, count := range []int{50, 0, 300, 1, 120} {
+				boxes := randomBoxes(rng, count, 5)
+
+				expected := buildIndex(t, func() Builder { return newBuilder() }, boxes, 8)
+
+				builder.Reset()
+				for i := 0; i < count; i++ {
+					builder.Add(int64(i), boxes[i*4], boxes[i*4+1], boxes[i*4+2], boxes[i*4+3])
+				}
+				actual, err := builder.Finish(8)
+				require.Nil(t, err)
+				require.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestAddBoxes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	boxes := randomBoxes(rng, 100, 5)
+	refs := make([]int64, 100)
+	for i := range refs {
+		refs[i] = int64(i)
+	}
+
+	for name, newBuilder := range optionsBuilders {
+		t.Run(name, func(t *testing.T) {
+			expected := buildIndex(t, func() Builder { return newBuilder() }, boxes, DefaultDegree)
+
+			builder := newBuilder()
+			builder.AddBoxes(refs[:30], boxes[:30*4])
+			builder.AddBoxes(nil, nil)
+			builder.AddBoxes(refs[30:], boxes[30*4:])
+			actual, err := builder.Finish(DefaultDegree)
+			require.Nil(t, err)
+			require.Equal(t, expected, actual)
+
+			require.Panics(t, func() { newBuilder().AddBoxes(refs[:2], boxes[:4]) })
+		})
+	}
+}
+
+func TestGrow(t *testing.T) {
+	for name, newBuilder := range optionsBuilders {
+		t.Run(name, func(t *testing.T) {
+			builder := newBuilder()
+			builder.Add(0, 0, 0, 1, 1)
+			// AllocsPerRun calls f once more to warm up
+			builder.Grow(200)
+
+			allocs := testing.AllocsPerRun(1, func() {
+				for i := 0; i < 100; i++ {
+					builder.Add(int64(i+1), 0, 0, 1, 1)
+				}
+			})
+			require.Zero(t, allocs)
+
+			index, err := builder.Finish(DefaultDegree)
+			require.Nil(t, err)
+			require.Equal(t, 201, index.Count())
+		})
+	}
+}
@@ -0,0 +1,640 @@
+package flatrtree
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ExternalHilbertOptions configures an ExternalHilbertBuilder.
+type ExternalHilbertOptions struct {
+	// Dir is the directory for temporary files, os.TempDir by default.
+	Dir string
+
+	// RunSize is the number of items held and sorted in memory at a
+	// time. Defaults to 1 << 20.
+	RunSize int
+
+	// Bits is the resolution of the Hilbert curve, as in HilbertOptions.
+	Bits int
+
+	// MaxOpenRuns is the number of runs merged at a time. With more
+	// runs, FinishTo first merges them in groups of MaxOpenRuns into
+	// longer runs, so at most MaxOpenRuns + 3 temporary files are open
+	// at once. Defaults to 128.
+	MaxOpenRuns int
+
+	// Strict makes FinishTo return an *InvalidBoxError listing the refs
+	// of invalid boxes, as BuildOptions.Strict does for Finish.
+	Strict bool
+}
+
+// ExternalHilbertBuilder builds the same index as a HilbertBuilder with
+// Parallelism above 1, where items with equal Hilbert values keep the
+// order they were added in, for inputs that do not fit in memory. Added
+// items are written to temporary files in runs of RunSize items. FinishTo
+// sorts each run by Hilbert value, merges the runs and streams the levels
+// of the tree into the serialized format, so memory use is bounded by one
+// run and a read buffer per merged run.
+//
+// Runs are written unsorted because Hilbert values depend on the extent
+// of all items, which is only known once the last item is added.
+type ExternalHilbertBuilder struct {
+	opts                   ExternalHilbertOptions
+	count                  int
+	refs                   []int64
+	boxes                  []float64
+	minX, minY, maxX, maxY float64
+
+	dir      string
+	runs     []string
+	invalid  []int64
+	omitted  int
+	err      error
+	finished bool
+}
+
+// maxExternalInvalidRefs is the most refs of invalid boxes
+// ExternalHilbertBuilder keeps for its InvalidBoxError.
+const maxExternalInvalidRefs = 1024
+
+func NewExternalHilbertBuilder(opts ExternalHilbertOptions) *ExternalHilbertBuilder {
+	if opts.RunSize <= 0 {
+		opts.RunSize = 1 << 20
+	}
+	if opts.Bits == 0 {
+		opts.Bits = 16
+	}
+	if opts.MaxOpenRuns < 2 {
+		opts.MaxOpenRuns = 128
+	}
+	return &ExternalHilbertBuilder{
+		minX: math.Inf(1),
+		minY: math.Inf(1),
+		maxX: math.Inf(-1),
+		maxY: math.Inf(-1),
+		opts: opts,
+		// with invalid bits, Add writes no runs and FinishTo fails
+		err: checkHilbertBits(opts.Bits),
+	}
+}
+
+// Add adds an item. Errors writing temporary files are reported by
+// FinishTo. Items added after FinishTo are ignored.
+func (b *ExternalHilbertBuilder) Add(ref int64, minX, minY, maxX, maxY float64) {
+	if b.err != nil || b.finished {
+		return
+	}
+
+	if b.opts.Strict && !validBox(minX, minY, maxX, maxY) {
+		// keep memory bounded however much of the input is invalid
+		if len(b.invalid) < maxExternalInvalidRefs {
+			b.invalid = append(b.invalid, ref)
+		} else {
+			b.omitted++
+		}
+		return
+	}
+
+	b.count++
+	b.refs = append(b.refs, ref)
+	b.boxes = append(b.boxes, minX, minY, maxX, maxY)
+	b.minX = math.Min(b.minX, minX)
+	b.minY = math.Min(b.minY, minY)
+	b.maxX = math.Max(b.maxX, maxX)
+	b.maxY = math.Max(b.maxY, maxY)
+
+	if len(b.refs) == b.opts.RunSize {
+		b.err = b.spill()
+	}
+}
+
+// FinishTo writes the index to w in the same bytes as SerializeWithOptions
+// of the index built by a HilbertBuilder with Parallelism above 1 and the
+// same items, and removes the temporary files.
+func (b *ExternalHilbertBuilder) FinishTo(w io.Writer, degree int, opts SerializeOptions) error {
+	if degree < 2 {
+		return ErrInvalidDegree
+	}
+
+	if b.finished {
+		return ErrFinishCalledTwice
+	}
+	b.finished = true
+	defer b.Close()
+
+	if b.err != nil {
+		return b.err
+	}
+
+	if len(b.invalid) > 0 {
+		return &InvalidBoxError{Refs: b.invalid, Omitted: b.omitted}
+	}
+
+	if err := checkCount(b.count); err != nil {
+		return err
+	}
+
+	pw := protoWriter{w: bufio.NewWriterSize(w, streamBufferSize)}
+
+	if b.count != 0 {
+		if err := b.write(&pw, degree, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.Precision != 0 {
+		pw.tag(4, protowire.VarintType)
+		pw.varint(uint64(opts.Precision))
+	}
+
+	return pw.w.Flush()
+}
+
+// Close removes the temporary files. FinishTo calls Close, so it is only
+// needed to discard a builder without finishing it.
+func (b *ExternalHilbertBuilder) Close() error {
+	if b.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(b.dir)
+	b.dir = ""
+	return err
+}
+
+// write encodes the refs and boxes of the tree into temporary files, to
+// learn the size of each field, then copies them to pw.
+func (b *ExternalHilbertBuilder) write(pw *protoWriter, degree int, opts SerializeOptions) error {
+	if len(b.refs) > 0 {
+		if err := b.spill(); err != nil {
+			return err
+		}
+	}
+
+	curve := newHilbertCurve(b.opts.Bits, b.minX, b.minY, b.maxX, b.maxY)
+	runs, err := b.sortRuns(curve)
+	if err != nil {
+		return err
+	}
+
+	runs, err = b.reduceRuns(runs)
+	if err != nil {
+		return err
+	}
+
+	scale := math.Pow10(int(opts.Precision))
+	levelSizes := packedLevelSizes(b.count, degree)
+
+	refsFile, err := os.Create(b.path("refs"))
+	if err != nil {
+		return err
+	}
+	defer refsFile.Close()
+
+	boxesFile, err := os.Create(b.path("boxes"))
+	if err != nil {
+		return err
+	}
+	defer boxesFile.Close()
+
+	refs := varintWriter{w: bufio.NewWriterSize(refsFile, streamBufferSize)}
+	boxes := varintWriter{w: bufio.NewWriterSize(boxesFile, streamBufferSize)}
+	writeBox := func(box []float64) {
+		for i, coord := range box {
+			boxes.varint(protowire.EncodeZigZag(quantize(coord, scale, i < 2, opts.Rounding)))
+		}
+	}
+
+	// items, grouped into the first level of nodes
+	err = b.writeLevel(levelName(1), degree, func(add func(box []float64)) error {
+		return mergeRuns(runs, func(c *runCursor) {
+			refs.varint(uint64(c.ref))
+			writeBox(c.box[:])
+			add(c.box[:])
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range runs {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	// nodes, grouped into the next level up to the root
+	for level := 1; level < len(levelSizes); level++ {
+		name := levelName(level)
+		readLevel := func(add func(box []float64)) error {
+			return readBoxes(b.path(name), func(box []float64) {
+				writeBox(box)
+				if add != nil {
+					add(box)
+				}
+			})
+		}
+
+		if level == len(levelSizes)-1 {
+			err = readLevel(nil)
+		} else {
+			err = b.writeLevel(levelName(level+1), degree, readLevel)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := os.Remove(b.path(name)); err != nil {
+			return err
+		}
+	}
+
+	if err := refs.w.Flush(); err != nil {
+		return err
+	}
+	if err := boxes.w.Flush(); err != nil {
+		return err
+	}
+
+	refsSize := refs.size
+	eachPackedNodeRef(levelSizes, degree, func(ref int64) {
+		refsSize += protowire.SizeVarint(uint64(ref))
+	})
+
+	pw.tag(1, protowire.VarintType)
+	pw.varint(uint64(b.count))
+
+	pw.tag(2, protowire.BytesType)
+	pw.varint(uint64(refsSize))
+	if err := copyFile(pw.w, refsFile); err != nil {
+		return err
+	}
+	eachPackedNodeRef(levelSizes, degree, func(ref int64) {
+		pw.varint(uint64(ref))
+	})
+
+	pw.tag(3, protowire.BytesType)
+	pw.varint(uint64(boxes.size))
+	return copyFile(pw.w, boxesFile)
+}
+
+// spill writes the items held in memory to a new run
+func (b *ExternalHilbertBuilder) spill() error {
+	if b.dir == "" {
+		dir, err := os.MkdirTemp(b.opts.Dir, "flatrtree-")
+		if err != nil {
+			return err
+		}
+		b.dir = dir
+	}
+
+	name := fmt.Sprintf("run-%d", len(b.runs))
+	err := writeFile(b.path(name), func(w *bufio.Writer) {
+		var buf [itemRecordSize]byte
+		for i, ref := range b.refs {
+			binary.LittleEndian.PutUint64(buf[:], uint64(ref))
+			putBox(buf[8:], b.boxes[i*4:i*4+4])
+			w.Write(buf[:])
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	b.runs = append(b.runs, name)
+	b.refs = b.refs[:0]
+	b.boxes = b.boxes[:0]
+	return nil
+}
+
+// sortRuns replaces each run with a run sorted by Hilbert value, then by
+// the order items were added in, and returns the paths of the sorted runs.
+func (b *ExternalHilbertBuilder) sortRuns(curve hilbertCurve) ([]string, error) {
+	var (
+		sorted []string
+		keys   []hilbertKey
+		offset int
+	)
+
+	for _, name := range b.runs {
+		b.refs = b.refs[:0]
+		b.boxes = b.boxes[:0]
+		err := readRecords(b.path(name), itemRecordSize, func(buf []byte) {
+			b.refs = append(b.refs, int64(binary.LittleEndian.Uint64(buf)))
+			b.boxes = appendBox(b.boxes, buf[8:])
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		keys = keys[:0]
+		for i := range b.refs {
+			keys = append(keys, hilbertKey{
+				value: curve.value(b.boxes[i*4], b.boxes[i*4+1], b.boxes[i*4+2], b.boxes[i*4+3]),
+				index: offset + i,
+			})
+		}
+		sort.Sort(hilbertKeys(keys))
+
+		path := b.path("sorted-" + name)
+		err = writeFile(path, func(w *bufio.Writer) {
+			var buf [keyedRecordSize]byte
+			for _, key := range keys {
+				i := key.index - offset
+				binary.LittleEndian.PutUint64(buf[:], key.value)
+				binary.LittleEndian.PutUint64(buf[8:], uint64(key.index))
+				binary.LittleEndian.PutUint64(buf[16:], uint64(b.refs[i]))
+				putBox(buf[24:], b.boxes[i*4:i*4+4])
+				w.Write(buf[:])
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.Remove(b.path(name)); err != nil {
+			return nil, err
+		}
+
+		sorted = append(sorted, path)
+		offset += len(b.refs)
+	}
+
+	b.runs = nil
+	b.refs = nil
+	b.boxes = nil
+
+	return sorted, nil
+}
+
+// reduceRuns merges sorted runs in groups of MaxOpenRuns into longer
+// sorted runs until at most MaxOpenRuns remain, and returns their paths.
+func (b *ExternalHilbertBuilder) reduceRuns(runs []string) ([]string, error) {
+	for pass := 0; len(runs) > b.opts.MaxOpenRuns; pass++ {
+		var merged []string
+		for start := 0; start < len(runs); start += b.opts.MaxOpenRuns {
+			end := start + b.opts.MaxOpenRuns
+			if end > len(runs) {
+				end = len(runs)
+			}
+
+			group := runs[start:end]
+			if len(group) == 1 {
+				merged = append(merged, group[0])
+				continue
+			}
+
+			var err error
+			path := b.path(fmt.Sprintf("merged-%d-%d", pass, len(merged)))
+			writeErr := writeFile(path, func(w *bufio.Writer) {
+				err = mergeRuns(group, func(c *runCursor) {
+					w.Write(c.buf[:])
+				})
+			})
+			if err != nil {
+				return nil, err
+			}
+			if writeErr != nil {
+				return nil, writeErr
+			}
+
+			for _, run := range group {
+				if err := os.Remove(run); err != nil {
+					return nil, err
+				}
+			}
+
+			merged = append(merged, path)
+		}
+		runs = merged
+	}
+
+	return runs, nil
+}
+
+// writeLevel writes the boxes of the nodes grouping the boxes passed to
+// add, in the same way as HilbertBuilder.pack.
+func (b *ExternalHilbertBuilder) writeLevel(name string, degree int, boxes func(add func(box []float64)) error) error {
+	var err error
+	writeErr := writeFile(b.path(name), func(w *bufio.Writer) {
+		var (
+			buf                    [boxRecordSize]byte
+			n                      int
+			minX, minY, maxX, maxY float64
+		)
+
+		flush := func() {
+			putBox(buf[:], []float64{minX, minY, maxX, maxY})
+			w.Write(buf[:])
+			n = 0
+		}
+
+		err = boxes(func(box []float64) {
+			if n == 0 {
+				minX, minY = math.Inf(1), math.Inf(1)
+				maxX, maxY = math.Inf(-1), math.Inf(-1)
+			}
+			minX = math.Min(minX, box[0])
+			minY = math.Min(minY, box[1])
+			maxX = math.Max(maxX, box[2])
+			maxY = math.Max(maxY, box[3])
+			n++
+			if n == degree {
+				flush()
+			}
+		})
+
+		if n > 0 {
+			flush()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+func (b *ExternalHilbertBuilder) path(name string) string {
+	return filepath.Join(b.dir, name)
+}
+
+func levelName(level int) string {
+	return fmt.Sprintf("level-%d", level)
+}
+
+//
+// Temporary files
+//
+
+const (
+	boxRecordSize   = 32                  // minX, minY, maxX, maxY
+	itemRecordSize  = 8 + boxRecordSize   // ref, box
+	keyedRecordSize = 16 + itemRecordSize // Hilbert value, index, ref, box
+)
+
+func putBox(buf []byte, box []float64) {
+	for i, coord := range box {
+		binary.LittleEndian.PutUint64(buf[i*8:], math.Float64bits(coord))
+	}
+}
+
+func appendBox(boxes []float64, buf []byte) []float64 {
+	for i := 0; i < 4; i++ {
+		boxes = append(boxes, math.Float64frombits(binary.LittleEndian.Uint64(buf[i*8:])))
+	}
+	return boxes
+}
+
+// writeFile creates the file at path and writes it with f. Errors are
+// retained by the bufio.Writer and reported by Flush.
+func writeFile(path string, f func(w *bufio.Writer)) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(file, streamBufferSize)
+	f(w)
+
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readRecords calls f with each record of size bytes in the file at path
+func readRecords(path string, size int, f func(buf []byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, streamBufferSize)
+	buf := make([]byte, size)
+	for {
+		if _, err := io.ReadFull(r, buf); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		f(buf)
+	}
+}
+
+func readBoxes(path string, f func(box []float64)) error {
+	box := make([]float64, 0, 4)
+	return readRecords(path, boxRecordSize, func(buf []byte) {
+		f(appendBox(box[:0], buf))
+	})
+}
+
+func copyFile(w io.Writer, file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, file)
+	return err
+}
+
+// varintWriter writes varints and counts the bytes written. Errors are
+// retained by the bufio.Writer and reported by Flush.
+type varintWriter struct {
+	w    *bufio.Writer
+	size int
+	buf  [binary.MaxVarintLen64]byte
+}
+
+func (v *varintWriter) varint(x uint64) {
+	b := protowire.AppendVarint(v.buf[:0], x)
+	v.w.Write(b)
+	v.size += len(b)
+}
+
+//
+// Merging
+//
+
+// mergeRuns calls f with a cursor at each item of the sorted runs in
+// Hilbert order. The cursor is only valid until f returns.
+func mergeRuns(paths []string, f func(c *runCursor)) error {
+	var cursors runHeap
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		c := &runCursor{r: bufio.NewReaderSize(file, streamBufferSize)}
+		if ok, err := c.next(); err != nil {
+			return err
+		} else if ok {
+			cursors = append(cursors, c)
+		}
+	}
+	heap.Init(&cursors)
+
+	for len(cursors) > 0 {
+		c := cursors[0]
+		f(c)
+
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&cursors, 0)
+		} else {
+			heap.Pop(&cursors)
+		}
+	}
+
+	return nil
+}
+
+// runCursor reads the items of a sorted run
+type runCursor struct {
+	r   *bufio.Reader
+	buf [keyedRecordSize]byte
+	key hilbertKey
+	ref int64
+	box [4]float64
+}
+
+func (c *runCursor) next() (bool, error) {
+	if _, err := io.ReadFull(c.r, c.buf[:]); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	c.key.value = binary.LittleEndian.Uint64(c.buf[:])
+	c.key.index = int(binary.LittleEndian.Uint64(c.buf[8:]))
+	c.ref = int64(binary.LittleEndian.Uint64(c.buf[16:]))
+	appendBox(c.box[:0], c.buf[24:])
+	return true, nil
+}
+
+type runHeap []*runCursor
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].key.less(h[j].key) }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runCursor)) }
+
+func (h *runHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,198 @@
+package flatrtree
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalHilbertBuilder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	items := randomBoxes(rng, 900, 5)
+	// duplicates share Hilbert values
+	items = append(items, items[:100*4]...)
+
+	for _, tc := range []struct {
+		name    string
+		count   int
+		degree  int
+		runSize int
+		opts    SerializeOptions
+	}{
+		{"empty", 0, DefaultDegree, 10, SerializeOptions{}},
+		{"empty with precision", 0, DefaultDegree, 10, SerializeOptions{Precision: 3}},
+		{"one item", 1, DefaultDegree, 10, SerializeOptions{Precision: 2}},
+		{"one node", DefaultDegree, DefaultDegree, 3, SerializeOptions{Precision: 2}},
+		{"one run", 1000, 5, 1000, SerializeOptions{Precision: 4}},
+		{"full runs", 1000, 5, 100, SerializeOptions{Precision: 4}},
+		{"partial run", 1000, 16, 333, SerializeOptions{Precision: 1}},
+		{"round nearest", 1000, 4, 7, SerializeOptions{Precision: 1, Rounding: RoundNearest}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			builder := NewHilbertBuilderWithOptions(HilbertOptions{Parallelism: 2})
+			external := NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: dir, RunSize: tc.runSize})
+			for i := 0; i < tc.count; i++ {
+				ref := int64(i) - 10 // negative refs have 10 byte varints
+				builder.Add(ref, items[i*4], items[i*4+1], items[i*4+2], items[i*4+3])
+				external.Add(ref, items[i*4], items[i*4+1], items[i*4+2], items[i*4+3])
+			}
+
+			index, err := builder.Finish(tc.degree)
+			require.Nil(t, err)
+			expected, err := SerializeWithOptions(index, tc.opts)
+			require.Nil(t, err)
+
+			var actual bytes.Buffer
+			require.Nil(t, external.FinishTo(&actual, tc.degree, tc.opts))
+			require.True(t, bytes.Equal(expected, actual.Bytes()))
+
+			entries, err := os.ReadDir(dir)
+			require.Nil(t, err)
+			require.Empty(t, entries)
+		})
+	}
+}
+
+func TestExternalHilbertBuilderCities(t *testing.T) {
+	for _, bits := range []int{16, 32} {
+		builder := NewHilbertBuilderWithOptions(HilbertOptions{Bits: bits, Parallelism: 2})
+		external := NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: t.TempDir(), RunSize: 10000, Bits: bits})
+		for i, city := range cities {
+			builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+			external.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+		}
+
+		index, err := builder.Finish(DefaultDegree)
+		require.Nil(t, err)
+		expected, err := Serialize(index, 6)
+		require.Nil(t, err)
+
+		var actual bytes.Buffer
+		require.Nil(t, external.FinishTo(&actual, DefaultDegree, SerializeOptions{Precision: 6}))
+		require.Equal(t, expected, actual.Bytes())
+	}
+}
+
+func TestExternalHilbertBuilderMaxOpenRuns(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	items := randomBoxes(rng, 1000, 5)
+	// duplicates share Hilbert values
+	items = append(items, items[:100*4]...)
+
+	builder := NewHilbertBuilderWithOptions(HilbertOptions{Parallelism: 2})
+	for i := 0; i < len(items)/4; i++ {
+		builder.Add(int64(i), items[i*4], items[i*4+1], items[i*4+2], items[i*4+3])
+	}
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+	expected, err := Serialize(index, 3)
+	require.Nil(t, err)
+
+	// 158 runs take several passes to merge
+	for _, maxOpenRuns := range []int{2, 3, 10, 158} {
+		dir := t.TempDir()
+		external := NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: dir, RunSize: 7, MaxOpenRuns: maxOpenRuns})
+		for i := 0; i < len(items)/4; i++ {
+			external.Add(int64(i), items[i*4], items[i*4+1], items[i*4+2], items[i*4+3])
+		}
+
+		var actual bytes.Buffer
+		require.Nil(t, external.FinishTo(&actual, DefaultDegree, SerializeOptions{Precision: 3}))
+		require.True(t, bytes.Equal(expected, actual.Bytes()), "MaxOpenRuns %d", maxOpenRuns)
+
+		entries, err := os.ReadDir(dir)
+		require.Nil(t, err)
+		require.Empty(t, entries)
+	}
+}
+
+func TestExternalHilbertBuilderErrors(t *testing.T) {
+	builder := NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: t.TempDir()})
+	builder.Add(0, 1, 1, 2, 2)
+
+	var buf bytes.Buffer
+	require.ErrorIs(t, builder.FinishTo(&buf, 1, SerializeOptions{}), ErrInvalidDegree)
+	require.Nil(t, builder.FinishTo(&buf, DefaultDegree, SerializeOptions{}))
+	require.ErrorIs(t, builder.FinishTo(&buf, DefaultDegree, SerializeOptions{}), ErrFinishCalledTwice)
+
+	// items added after FinishTo do not create temporary files
+	dir := t.TempDir()
+	builder = NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: dir, RunSize: 1})
+	require.Nil(t, builder.FinishTo(&buf, DefaultDegree, SerializeOptions{}))
+	builder.Add(0, 1, 1, 2, 2)
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+
+	// strict mode reports invalid boxes
+	dir = t.TempDir()
+	builder = NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: dir, RunSize: 1, Strict: true})
+	builder.Add(0, 1, 1, 2, 2)
+	builder.Add(1, math.NaN(), 1, 2, 2)
+	builder.Add(2, 3, 1, 2, 2)
+	err = builder.FinishTo(&buf, DefaultDegree, SerializeOptions{})
+	var boxErr *InvalidBoxError
+	require.ErrorAs(t, err, &boxErr)
+	require.Equal(t, []int64{1, 2}, boxErr.Refs)
+	entries, err = os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+
+	// only the first invalid refs are kept
+	builder = NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: t.TempDir(), Strict: true})
+	for i := 0; i < maxExternalInvalidRefs+5; i++ {
+		builder.Add(int64(i), math.NaN(), 1, 2, 2)
+	}
+	err = builder.FinishTo(&buf, DefaultDegree, SerializeOptions{})
+	require.ErrorAs(t, err, &boxErr)
+	require.Len(t, boxErr.Refs, maxExternalInvalidRefs)
+	require.Equal(t, 5, boxErr.Omitted)
+
+	// the count does not fit the format
+	if strconv.IntSize == 64 {
+		builder = NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: t.TempDir()})
+		builder.Add(0, 1, 1, 2, 2)
+		count := uint64(math.MaxUint32) + 1
+		builder.count = int(count)
+		require.ErrorIs(t, builder.FinishTo(&buf, DefaultDegree, SerializeOptions{}), ErrTooManyItems)
+	}
+
+	// invalid bits are reported before any run is written
+	dir = t.TempDir()
+	builder = NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: dir, RunSize: 1, Bits: 24})
+	builder.Add(0, 1, 1, 2, 2)
+	entries, err = os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+	require.ErrorIs(t, builder.FinishTo(&buf, DefaultDegree, SerializeOptions{}), ErrInvalidBits)
+
+	// temporary files cannot be created
+	builder = NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: "/nonexistent", RunSize: 1})
+	builder.Add(0, 1, 1, 2, 2)
+	require.NotNil(t, builder.FinishTo(&buf, DefaultDegree, SerializeOptions{}))
+}
+
+func TestExternalHilbertBuilderClose(t *testing.T) {
+	dir := t.TempDir()
+	builder := NewExternalHilbertBuilder(ExternalHilbertOptions{Dir: dir, RunSize: 2})
+	for i := 0; i < 5; i++ {
+		builder.Add(int64(i), float64(i), float64(i), float64(i), float64(i))
+	}
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+
+	require.Nil(t, builder.Close())
+	entries, err = os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,392 @@
+package flatrtree
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var _ Index = &FixedRTree{}
+
+// ErrClosed is returned by queries on a FixedRTree after Close
+var ErrClosed = errors.New("flatrtree: index closed")
+
+// BoxEncoding selects how boxes are stored in the fixed-width format.
+type BoxEncoding uint8
+
+const (
+	// BoxFloat64 stores coordinates as float64 values
+	BoxFloat64 BoxEncoding = iota
+	// BoxInt32 stores coordinates multiplied by 10^precision as int32
+	// values, rounded outward like Serialize.
+	BoxInt32
+)
+
+// FixedOptions configures SerializeFixed.
+type FixedOptions struct {
+	Encoding BoxEncoding
+	// Precision is the number of decimal places kept by BoxInt32
+	Precision uint32
+	// PageSize, if set, aligns the refs and boxes sections to multiples
+	// of PageSize bytes for readers that fetch whole pages, such as
+	// OpenRemote. It must be a multiple of 8.
+	PageSize uint32
+}
+
+// The fixed-width format is a 32 byte header followed by refs and boxes
+// as little-endian arrays, so any value can be read without decoding
+// the values before it:
+//
+//	+---------------------------------------------------------------+
+//	| magic | version | encoding | count | len(refs) | precision |  |
+//	| page size                                                     |
+//	+---------------------------------------------------------------+
+//	| refs: len(refs) x int64                                       |
+//	+---------------------------------------------------------------+
+//	| boxes: (len(refs)-1) x 4 x float64 or int32                   |
+//	+---------------------------------------------------------------+
+//
+// The layout of refs and boxes is the same as in the protobuf format.
+// With a page size, each section starts at a multiple of the page size
+// and the gaps are zero padded.
+const (
+	fixedMagic      = "frtf"
+	fixedVersion    = 1
+	fixedHeaderSize = 32
+)
+
+var errFixedFormat = errors.New("flatrtree: invalid fixed-width index")
+
+// SerializeFixed encodes the index in the fixed-width format.
+func SerializeFixed(index *RTree, opts FixedOptions) ([]byte, error) {
+	var boxSize int
+	switch opts.Encoding {
+	case BoxFloat64:
+		boxSize = 8
+	case BoxInt32:
+		boxSize = 4
+	default:
+		return nil, errors.New("flatrtree: unknown box encoding")
+	}
+
+	if opts.PageSize%8 != 0 {
+		return nil, errors.New("flatrtree: page size must be a multiple of 8")
+	}
+
+	refsOff, boxesOff, size := fixedLayout(int64(len(index.refs)), int64(boxSize), int64(opts.PageSize))
+	b := make([]byte, size)
+
+	copy(b, fixedMagic)
+	b[4] = fixedVersion
+	b[5] = byte(opts.Encoding)
+	binary.LittleEndian.PutUint64(b[8:], uint64(index.count))
+	binary.LittleEndian.PutUint64(b[16:], uint64(len(index.refs)))
+	binary.LittleEndian.PutUint32(b[24:], opts.Precision)
+	binary.LittleEndian.PutUint32(b[28:], opts.PageSize)
+
+	pos := refsOff
+	for _, ref := range index.refs {
+		binary.LittleEndian.PutUint64(b[pos:], uint64(ref))
+		pos += 8
+	}
+
+	pos = boxesOff
+
+	scale := math.Pow10(int(opts.Precision))
+	for i, coord := range index.boxes {
+		if opts.Encoding == BoxFloat64 {
+			binary.LittleEndian.PutUint64(b[pos:], math.Float64bits(coord))
+		} else {
+			q := quantize(coord, scale, i%4 < 2, RoundOutward)
+			if q < math.MinInt32 || q > math.MaxInt32 {
+				return nil, errors.New("flatrtree: coordinate out of int32 range, reduce precision")
+			}
+			binary.LittleEndian.PutUint32(b[pos:], uint32(int32(q)))
+		}
+		pos += int64(boxSize)
+	}
+
+	return b, nil
+}
+
+// fixedLayout returns the offsets of the refs and boxes sections
+// and the total size in bytes of an index in the fixed-width format.
+func fixedLayout(refsLen, boxSize, pageSize int64) (refsOff, boxesOff, size int64) {
+	numBoxes := int64(0)
+	if refsLen > 0 {
+		numBoxes = refsLen - 1
+	}
+
+	refsOff = alignUp(fixedHeaderSize, pageSize)
+	boxesOff = alignUp(refsOff+refsLen*8, pageSize)
+	size = boxesOff + numBoxes*4*boxSize
+	return refsOff, boxesOff, size
+}
+
+func alignUp(n, pageSize int64) int64 {
+	if pageSize == 0 {
+		return n
+	}
+	return (n + pageSize - 1) / pageSize * pageSize
+}
+
+// fixedHeader describes an index in the fixed-width format.
+type fixedHeader struct {
+	count    int64
+	refsLen  int64
+	encoding BoxEncoding
+	boxSize  int64
+	scale    float64
+	pageSize int64
+	refsOff  int64
+	boxesOff int64
+	size     int64
+}
+
+func parseFixedHeader(b []byte) (fixedHeader, error) {
+	if len(b) < fixedHeaderSize || string(b[:4]) != fixedMagic || b[4] != fixedVersion {
+		return fixedHeader{}, errFixedFormat
+	}
+
+	h := fixedHeader{
+		count:    int64(binary.LittleEndian.Uint64(b[8:])),
+		refsLen:  int64(binary.LittleEndian.Uint64(b[16:])),
+		encoding: BoxEncoding(b[5]),
+		scale:    math.Pow10(int(binary.LittleEndian.Uint32(b[24:]))),
+		pageSize: int64(binary.LittleEndian.Uint32(b[28:])),
+	}
+
+	switch h.encoding {
+	case BoxFloat64:
+		h.boxSize = 8
+	case BoxInt32:
+		h.boxSize = 4
+	default:
+		return fixedHeader{}, errFixedFormat
+	}
+
+	// guard against overflow when computing the layout
+	if h.count < 0 || h.refsLen < 0 || h.refsLen > math.MaxInt64/64 || h.pageSize%8 != 0 {
+		return fixedHeader{}, errFixedFormat
+	}
+	if h.count == 0 && h.refsLen != 0 || h.count > 0 && h.refsLen < h.count+2 {
+		return fixedHeader{}, errFixedFormat
+	}
+
+	h.refsOff, h.boxesOff, h.size = fixedLayout(h.refsLen, h.boxSize, h.pageSize)
+	return h, nil
+}
+
+// refsSpan returns the byte range holding refs[start:start+n]
+func (h *fixedHeader) refsSpan(start, n int64) (off, length int64, err error) {
+	if start < 0 || n < 0 || start+n > h.refsLen {
+		return 0, 0, ErrCorruptNode
+	}
+	return h.refsOff + start*8, n * 8, nil
+}
+
+// boxesSpan returns the byte range holding n coordinates
+// starting with the first coordinate of box start
+func (h *fixedHeader) boxesSpan(start, n int64) (off, length int64, err error) {
+	start *= 4
+	if start < 0 || n < 0 || start+n > 4*(h.refsLen-1) {
+		return 0, 0, ErrCorruptNode
+	}
+	return h.boxesOff + start*h.boxSize, n * h.boxSize, nil
+}
+
+func (h *fixedHeader) decodeRefs(dst []int64, b []byte) {
+	for i := range dst {
+		dst[i] = int64(binary.LittleEndian.Uint64(b[i*8:]))
+	}
+}
+
+func (h *fixedHeader) decodeBoxes(dst []float64, b []byte) {
+	if h.encoding == BoxFloat64 {
+		for i := range dst {
+			dst[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+		}
+		return
+	}
+
+	for i := range dst {
+		dst[i] = float64(int32(binary.LittleEndian.Uint32(b[i*4:]))) / h.scale
+	}
+}
+
+// FixedRTree queries an index in the fixed-width format in place.
+// Opening it reads only the header.
+type FixedRTree struct {
+	fixedHeader
+	data   []byte
+	close  func() error
+	closed bool
+}
+
+// OpenFixed returns a FixedRTree backed by b, which must not be modified.
+func OpenFixed(b []byte) (*FixedRTree, error) {
+	h, err := parseFixedHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(b)) != h.size {
+		return nil, errFixedFormat
+	}
+
+	return &FixedRTree{fixedHeader: h, data: b}, nil
+}
+
+// DeserializeFixed decodes an index in the fixed-width format. Like
+// Deserialize, it returns a *ValidationError if the layout of the index
+// would make queries panic.
+func DeserializeFixed(b []byte) (*RTree, error) {
+	t, err := OpenFixed(b)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &RTree{count: int(t.count)}
+	if t.count == 0 {
+		return index, nil
+	}
+
+	index.refs = make([]int64, t.refsLen)
+	index.boxes = make([]float64, 4*(t.refsLen-1))
+
+	if err := t.readRefs(index.refs, 0); err != nil {
+		return nil, err
+	}
+	if err := t.readBoxes(index.boxes, 0); err != nil {
+		return nil, err
+	}
+
+	// like Deserialize, check the layout queries rely on
+	if err := index.checkLayout(); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// ConvertToFixed converts the output of Serialize to the fixed-width format.
+func ConvertToFixed(data []byte, opts FixedOptions) ([]byte, error) {
+	index, err := Deserialize(data)
+	if err != nil {
+		return nil, err
+	}
+	return SerializeFixed(index, opts)
+}
+
+// ConvertFromFixed converts an index in the fixed-width
+// format to the output of Serialize.
+func ConvertFromFixed(b []byte, precision uint32) ([]byte, error) {
+	index, err := DeserializeFixed(b)
+	if err != nil {
+		return nil, err
+	}
+	return Serialize(index, precision)
+}
+
+// Close releases the memory mapping of an index opened with OpenMmap.
+// Queries after Close return ErrClosed, or panic with it, for an index
+// opened with either OpenMmap or OpenFixed.
+func (t *FixedRTree) Close() error {
+	t.closed = true
+	if t.close == nil {
+		return nil
+	}
+	err := t.close()
+	t.close = nil
+	t.data = nil
+	return err
+}
+
+// Count returns the number of items in the index
+func (t *FixedRTree) Count() int {
+	return int(t.count)
+}
+
+// Search calls the iterf function for all items intersecting the
+// search box. If iterf returns false the search will terminate.
+//
+// OpenFixed and OpenMmap only check the header and size, so Search
+// panics if it visits a corrupt node. Use SearchErr for data from
+// untrusted sources.
+func (t *FixedRTree) Search(
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64) (next bool),
+) {
+	if err := t.SearchErr(minX, minY, maxX, maxY, iterf); err != nil {
+		panic(err)
+	}
+}
+
+// SearchErr is Search, returning an error instead of panicking if
+// it visits a corrupt node or the index is closed.
+func (t *FixedRTree) SearchErr(
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64) (next bool),
+) error {
+	if t.closed {
+		return ErrClosed
+	}
+	return searchSource(t, minX, minY, maxX, maxY, iterf)
+}
+
+// Neighbors calls the iterf function for all items in ascending order of distance
+// to the given coordinates. If iterf returns false the search will terminate.
+//
+// See RTree.Neighbors for a description of boxDist and itemDist. Like
+// Search, Neighbors panics if it visits a corrupt node; use NeighborsErr
+// for data from untrusted sources.
+func (t *FixedRTree) Neighbors(
+	x, y float64,
+	iterf func(ref int64, dist float64) (next bool),
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) {
+	if err := t.NeighborsErr(x, y, iterf, boxDist, itemDist); err != nil {
+		panic(err)
+	}
+}
+
+// NeighborsErr is Neighbors, returning an error instead of panicking
+// if it visits a corrupt node or the index is closed.
+func (t *FixedRTree) NeighborsErr(
+	x, y float64,
+	iterf func(ref int64, dist float64) (next bool),
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) error {
+	if t.closed {
+		return ErrClosed
+	}
+	return neighborsSource(t, x, y, iterf, boxDist, itemDist)
+}
+
+func (t *FixedRTree) numItems() int64 {
+	return t.count
+}
+
+func (t *FixedRTree) numRefs() int64 {
+	return t.refsLen
+}
+
+func (t *FixedRTree) readRefs(dst []int64, start int64) error {
+	off, length, err := t.refsSpan(start, int64(len(dst)))
+	if err != nil {
+		return err
+	}
+	t.decodeRefs(dst, t.data[off:off+length])
+	return nil
+}
+
+func (t *FixedRTree) readBoxes(dst []float64, start int64) error {
+	off, length, err := t.boxesSpan(start, int64(len(dst)))
+	if err != nil {
+		return err
+	}
+	t.decodeBoxes(dst, t.data[off:off+length])
+	return nil
+}
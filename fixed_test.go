@@ -0,0 +1,237 @@
+package flatrtree
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedRoundTrip(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, opts := range []FixedOptions{{Encoding: BoxFloat64}, {Encoding: BoxInt32, Precision: 3}} {
+				data, err := SerializeFixed(tc.index, opts)
+				require.Nil(t, err)
+
+				index, err := DeserializeFixed(data)
+				require.Nil(t, err)
+
+				require.Equal(t, tc.index.count, index.count)
+				require.Equal(t, len(tc.index.refs), len(index.refs))
+				require.Equal(t, len(tc.index.boxes), len(index.boxes))
+				for i := range tc.index.refs {
+					require.Equal(t, tc.index.refs[i], index.refs[i])
+				}
+				for i := range tc.index.boxes {
+					require.Equal(t, tc.index.boxes[i], index.boxes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFixedQueries(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, opts := range []FixedOptions{{Encoding: BoxFloat64}, {Encoding: BoxInt32}} {
+				data, err := SerializeFixed(tc.index, opts)
+				require.Nil(t, err)
+
+				fixed, err := OpenFixed(data)
+				require.Nil(t, err)
+				require.Equal(t, tc.index.Count(), fixed.Count())
+
+				for i := 0; i < tc.count; i++ {
+					var expected, actual []int64
+					tc.index.Search(tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3], func(ref int64) bool {
+						expected = append(expected, ref)
+						return true
+					})
+					fixed.Search(tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3], func(ref int64) bool {
+						actual = append(actual, ref)
+						return true
+					})
+					require.Equal(t, expected, actual)
+
+					expected, actual = nil, nil
+					tc.index.Neighbors(tc.items[i*4], tc.items[i*4+1], func(ref int64, dist float64) bool {
+						expected = append(expected, ref)
+						return true
+					}, PlanarBoxDist, nil)
+					fixed.Neighbors(tc.items[i*4], tc.items[i*4+1], func(ref int64, dist float64) bool {
+						actual = append(actual, ref)
+						return true
+					}, PlanarBoxDist, nil)
+					require.Equal(t, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestFixedInt32Range(t *testing.T) {
+	builder := NewHilbertBuilder()
+	builder.Add(0, -180, -90, 180, 90)
+
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+
+	_, err = SerializeFixed(index, FixedOptions{Encoding: BoxInt32, Precision: 7})
+	require.Nil(t, err)
+
+	_, err = SerializeFixed(index, FixedOptions{Encoding: BoxInt32, Precision: 8})
+	require.NotNil(t, err)
+}
+
+func TestFixedConversion(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["OMT"], 100, DefaultDegree)
+
+	data, err := Serialize(index, 2)
+	require.Nil(t, err)
+
+	fixed, err := ConvertToFixed(data, FixedOptions{Encoding: BoxInt32, Precision: 2})
+	require.Nil(t, err)
+
+	actual, err := ConvertFromFixed(fixed, 2)
+	require.Nil(t, err)
+	require.Equal(t, data, actual)
+}
+
+func TestOpenFixedInvalid(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	data, err := SerializeFixed(index, FixedOptions{})
+	require.Nil(t, err)
+
+	_, err = OpenFixed(data[:fixedHeaderSize-1])
+	require.NotNil(t, err)
+
+	_, err = OpenFixed(data[:len(data)-1])
+	require.NotNil(t, err)
+
+	corrupt := append([]byte{}, data...)
+	corrupt[0] = 'x'
+	_, err = OpenFixed(corrupt)
+	require.NotNil(t, err)
+
+	corrupt = append([]byte{}, data...)
+	corrupt[5] = 9
+	_, err = OpenFixed(corrupt)
+	require.NotNil(t, err)
+
+	corrupt = append([]byte{}, data...)
+	corrupt[16] = 0xff
+	_, err = OpenFixed(corrupt)
+	require.NotNil(t, err)
+}
+
+func TestFixedCorruptNode(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	data, err := SerializeFixed(index, FixedOptions{})
+	require.Nil(t, err)
+
+	// the root's children end past the root
+	rootRefIdx := len(index.refs) - 2
+	binary.LittleEndian.PutUint64(data[fixedHeaderSize+8*(rootRefIdx+1):], uint64(len(index.boxes)+4))
+
+	fixed, err := OpenFixed(data)
+	require.Nil(t, err)
+
+	err = fixed.SearchErr(0, 0, 100, 100, func(ref int64) bool { return true })
+	require.ErrorIs(t, err, ErrCorruptNode)
+	require.Panics(t, func() {
+		fixed.Search(0, 0, 100, 100, func(ref int64) bool { return true })
+	})
+
+	err = fixed.NeighborsErr(0, 0, func(ref int64, dist float64) bool { return true }, PlanarBoxDist, nil)
+	require.ErrorIs(t, err, ErrCorruptNode)
+	require.Panics(t, func() {
+		fixed.Neighbors(0, 0, func(ref int64, dist float64) bool { return true }, PlanarBoxDist, nil)
+	})
+
+	_, err = DeserializeFixed(data)
+	require.ErrorIs(t, err, ErrInvalidChildren)
+}
+
+func TestOpenMmap(t *testing.T) {
+	builder := NewHilbertBuilder()
+	for i, city := range cities {
+		builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+	}
+
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+
+	data, err := SerializeFixed(index, FixedOptions{Encoding: BoxInt32, Precision: 5})
+	require.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "cities.frtf")
+	require.Nil(t, os.WriteFile(path, data, 0o644))
+
+	mapped, err := OpenMmap(path)
+	require.Nil(t, err)
+	defer mapped.Close()
+
+	require.Equal(t, index.Count(), mapped.Count())
+
+	for _, city := range cities[:100] {
+		expected := make(map[int64]bool)
+		index.Search(city.Lon-1, city.Lat-1, city.Lon+1, city.Lat+1, func(ref int64) bool {
+			expected[ref] = true
+			return true
+		})
+
+		// int32 boxes are rounded outward, so results can only grow
+		actual := make(map[int64]bool)
+		mapped.Search(city.Lon-1, city.Lat-1, city.Lon+1, city.Lat+1, func(ref int64) bool {
+			actual[ref] = true
+			return true
+		})
+		for ref := range expected {
+			require.True(t, actual[ref])
+		}
+
+		var nearest int64 = -1
+		mapped.Neighbors(city.Lon, city.Lat, func(ref int64, dist float64) bool {
+			nearest = ref
+			return false
+		}, GeodeticBoxDist, nil)
+		require.NotEqual(t, int64(-1), nearest)
+		require.InDelta(t, 0, GeodeticBoxDist(city.Lon, city.Lat, cities[nearest].Lon, cities[nearest].Lat, cities[nearest].Lon, cities[nearest].Lat), 2)
+	}
+
+	require.Nil(t, mapped.Close())
+	require.Nil(t, mapped.Close())
+
+	// queries after Close fail clearly rather than reading unmapped memory
+	err = mapped.SearchErr(0, 0, 1, 1, func(ref int64) bool { return true })
+	require.ErrorIs(t, err, ErrClosed)
+	err = mapped.NeighborsErr(0, 0, func(ref int64, dist float64) bool { return true }, PlanarBoxDist, nil)
+	require.ErrorIs(t, err, ErrClosed)
+	require.PanicsWithValue(t, ErrClosed, func() {
+		mapped.Search(0, 0, 1, 1, func(ref int64) bool { return true })
+	})
+
+	_, err = OpenMmap(filepath.Join(t.TempDir(), "missing"))
+	require.NotNil(t, err)
+}
+
+func TestFixedEmpty(t *testing.T) {
+	data, err := SerializeFixed(&RTree{}, FixedOptions{})
+	require.Nil(t, err)
+	require.Len(t, data, fixedHeaderSize)
+
+	fixed, err := OpenFixed(data)
+	require.Nil(t, err)
+	require.Equal(t, 0, fixed.Count())
+
+	fixed.Search(math.Inf(-1), math.Inf(-1), math.Inf(1), math.Inf(1), func(ref int64) bool {
+		t.Fatal("unexpected result")
+		return true
+	})
+}
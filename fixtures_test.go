@@ -0,0 +1,31 @@
+package flatrtree
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// buildIndex adds boxes to a new builder with their positions as refs.
+func buildIndex(t testing.TB, newBuilder func() Builder, boxes []float64, degree int) *RTree {
+	builder := newBuilder()
+	for i := 0; i < len(boxes)/4; i++ {
+		builder.Add(int64(i), boxes[i*4], boxes[i*4+1], boxes[i*4+2], boxes[i*4+3])
+	}
+
+	index, err := builder.Finish(degree)
+	require.Nil(t, err)
+
+	return index
+}
+
+// randomBoxes returns n boxes with corners in [0, 100) and sides up to size.
+func randomBoxes(rng *rand.Rand, n int, size float64) []float64 {
+	boxes := make([]float64, 0, n*4)
+	for i := 0; i < n; i++ {
+		x, y := rng.Float64()*100, rng.Float64()*100
+		boxes = append(boxes, x, y, x+rng.Float64()*size, y+rng.Float64()*size)
+	}
+	return boxes
+}
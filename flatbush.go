@@ -0,0 +1,448 @@
+package flatrtree
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Flatbush serializes an index as an 8 byte header followed by a typed
+// array of boxes and a typed array of indices, using the same bottom-up
+// packed layout as HilbertBuilder:
+//
+//	+---------------------------------------------------------------+
+//	| 0xfb | version << 4 + array type | node size | number of items |
+//	+---------------------------------------------------------------+
+//	| boxes: number of nodes x 4 x array type                       |
+//	+---------------------------------------------------------------+
+//	| indices: number of nodes x uint16 or uint32                   |
+//	+---------------------------------------------------------------+
+//
+// Indices of items are their refs. Indices of nodes are the position of
+// their first child in boxes, the same as refs in RTree. The last child
+// is implied by the node size and the bounds of each level.
+const (
+	flatbushMagic      = 0xfb
+	flatbushVersion    = 3
+	flatbushHeaderSize = 8
+	flatbushFloat64    = 8
+)
+
+// flatbushArrayTypes are the byte sizes of the typed arrays
+// Flatbush supports, in the order of their type index.
+var flatbushArrayTypes = []int{
+	1, // Int8Array
+	1, // Uint8Array
+	1, // Uint8ClampedArray
+	2, // Int16Array
+	2, // Uint16Array
+	4, // Int32Array
+	4, // Uint32Array
+	4, // Float32Array
+	8, // Float64Array
+}
+
+var (
+	errFlatbushLayout = errors.New("flatrtree: index does not have a Flatbush layout, build it with HilbertBuilder")
+	errFlatbushFormat = errors.New("flatrtree: invalid Flatbush data")
+	errFlatGeobuf     = errors.New("flatrtree: invalid FlatGeobuf data")
+)
+
+// ExportFlatbush encodes an index built by HilbertBuilder in the binary
+// format of the Flatbush JavaScript library, with Float64Array boxes.
+// Refs must fit in the Flatbush index array, which is a Uint16Array for
+// indexes with fewer than 16384 nodes and a Uint32Array otherwise.
+func ExportFlatbush(index *RTree) ([]byte, error) {
+	count := index.count
+	if count == 0 {
+		return nil, errors.New("flatrtree: Flatbush does not support empty indexes")
+	}
+
+	// the first node holds a full set of children unless it is the root
+	nodeSize := int(index.refs[count+1]-index.refs[count]) / 4
+	if nodeSize < 2 {
+		nodeSize = 2
+	}
+	if nodeSize > math.MaxUint16 || count > math.MaxUint32 {
+		return nil, errFlatbushLayout
+	}
+
+	nodeRefs := packedNodeRefs(packedLevelSizes(count, nodeSize), nodeSize)
+	if len(nodeRefs) != len(index.refs)-count {
+		return nil, errFlatbushLayout
+	}
+	for i, ref := range nodeRefs {
+		if index.refs[count+i] != ref {
+			return nil, errFlatbushLayout
+		}
+	}
+
+	numNodes := len(index.boxes) / 4
+	indexSize := 2
+	if numNodes >= 16384 {
+		indexSize = 4
+	}
+
+	b := make([]byte, flatbushHeaderSize+numNodes*4*8+numNodes*indexSize)
+	b[0] = flatbushMagic
+	b[1] = flatbushVersion<<4 + flatbushFloat64
+	binary.LittleEndian.PutUint16(b[2:], uint16(nodeSize))
+	binary.LittleEndian.PutUint32(b[4:], uint32(count))
+
+	pos := flatbushHeaderSize
+	for _, coord := range index.boxes {
+		binary.LittleEndian.PutUint64(b[pos:], math.Float64bits(coord))
+		pos += 8
+	}
+
+	// refs[numNodes] closes the root's children, Flatbush does not store it
+	for _, ref := range index.refs[:numNodes] {
+		if ref < 0 || indexSize == 2 && ref > math.MaxUint16 || ref > math.MaxUint32 {
+			return nil, errors.New("flatrtree: ref out of range for Flatbush indices")
+		}
+		if indexSize == 2 {
+			binary.LittleEndian.PutUint16(b[pos:], uint16(ref))
+		} else {
+			binary.LittleEndian.PutUint32(b[pos:], uint32(ref))
+		}
+		pos += indexSize
+	}
+
+	return b, nil
+}
+
+// ImportFlatbush decodes an index in the binary format of the
+// Flatbush JavaScript library.
+func ImportFlatbush(b []byte) (*RTree, error) {
+	if len(b) < flatbushHeaderSize || b[0] != flatbushMagic || b[1]>>4 != flatbushVersion {
+		return nil, errFlatbushFormat
+	}
+
+	arrayType := int(b[1] & 0x0f)
+	if arrayType >= len(flatbushArrayTypes) {
+		return nil, errFlatbushFormat
+	}
+	arraySize := flatbushArrayTypes[arrayType]
+
+	nodeSize := int(binary.LittleEndian.Uint16(b[2:]))
+	if nodeSize < 2 {
+		nodeSize = 2
+	}
+
+	count := int(binary.LittleEndian.Uint32(b[4:]))
+	if count == 0 {
+		return nil, errFlatbushFormat
+	}
+
+	levelSizes := packedLevelSizes(count, nodeSize)
+	numNodes := packedNumNodes(levelSizes)
+
+	indexSize := 2
+	if numNodes >= 16384 {
+		indexSize = 4
+	}
+
+	boxesEnd := flatbushHeaderSize + numNodes*4*arraySize
+	if len(b) != boxesEnd+numNodes*indexSize {
+		return nil, errFlatbushFormat
+	}
+
+	nodeRefs := packedNodeRefs(levelSizes, nodeSize)
+
+	index := &RTree{
+		count: count,
+		refs:  make([]int64, numNodes+1),
+		boxes: make([]float64, numNodes*4),
+	}
+
+	pos := flatbushHeaderSize
+	for i := range index.boxes {
+		index.boxes[i] = decodeFlatbushValue(b[pos:], arrayType)
+		pos += arraySize
+	}
+
+	for i := 0; i < numNodes; i++ {
+		if indexSize == 2 {
+			index.refs[i] = int64(binary.LittleEndian.Uint16(b[pos:]))
+		} else {
+			index.refs[i] = int64(binary.LittleEndian.Uint32(b[pos:]))
+		}
+		pos += indexSize
+
+		// node indices must agree with the implied layout
+		if i >= count && index.refs[i] != nodeRefs[i-count] {
+			return nil, errFlatbushFormat
+		}
+	}
+	index.refs[numNodes] = nodeRefs[len(nodeRefs)-1]
+
+	return index, nil
+}
+
+func decodeFlatbushValue(b []byte, arrayType int) float64 {
+	switch arrayType {
+	case 0:
+		return float64(int8(b[0]))
+	case 1, 2:
+		return float64(b[0])
+	case 3:
+		return float64(int16(binary.LittleEndian.Uint16(b)))
+	case 4:
+		return float64(binary.LittleEndian.Uint16(b))
+	case 5:
+		return float64(int32(binary.LittleEndian.Uint32(b)))
+	case 6:
+		return float64(binary.LittleEndian.Uint32(b))
+	case 7:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	default:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+}
+
+// packedNodeRefs returns refs[count:] for the packed layout shared by
+// HilbertBuilder, Flatbush and FlatGeobuf, where every node except the
+// last on each level has nodeSize children.
+func packedNodeRefs(levelSizes []int, nodeSize int) []int64 {
+	refs := make([]int64, 0, packedNumNodes(levelSizes)-levelSizes[0]+1)
+	eachPackedNodeRef(levelSizes, nodeSize, func(ref int64) {
+		refs = append(refs, ref)
+	})
+	return refs
+}
+
+// eachPackedNodeRef calls f with each value of packedNodeRefs in order
+func eachPackedNodeRef(levelSizes []int, nodeSize int, f func(ref int64)) {
+	f(0)
+
+	levelStart := 0
+	for level := 0; level < len(levelSizes)-1; level++ {
+		levelEnd := levelStart + levelSizes[level]
+		for start := levelStart; start < levelEnd; start += nodeSize {
+			end := start + nodeSize
+			if end > levelEnd {
+				end = levelEnd
+			}
+			f(int64(end * 4))
+		}
+		levelStart = levelEnd
+	}
+}
+
+// packedNumNodes returns the total number of boxes in the packed layout
+func packedNumNodes(levelSizes []int) int {
+	numNodes := 0
+	for _, n := range levelSizes {
+		numNodes += n
+	}
+	return numNodes
+}
+
+// packedLevelSizes returns the number of boxes on each level of
+// the packed layout, starting with the items.
+func packedLevelSizes(count, nodeSize int) []int {
+	n := count
+	sizes := []int{n}
+	for {
+		n = (n + nodeSize - 1) / nodeSize
+		sizes = append(sizes, n)
+		if n == 1 {
+			return sizes
+		}
+	}
+}
+
+// FlatGeobuf files start with magic bytes, a size prefixed FlatBuffers
+// header and the packed R-tree index. The index uses the same packed
+// layout as Flatbush, but stores levels from the root down as 40 byte
+// node items:
+//
+//	minX, minY, maxX, maxY float64, offset uint64
+//
+// The offset of a node item is the position of its first child. The
+// offset of an item is the byte offset of its feature.
+const (
+	flatgeobufMagicSize = 8
+	flatgeobufNodeSize  = 40
+
+	// fields of the FlatGeobuf header table
+	flatgeobufFeaturesCount = 8
+	flatgeobufIndexNodeSize = 9
+)
+
+// ReadFlatGeobufIndex reads the packed R-tree index of a FlatGeobuf
+// file. The refs of the returned index are the byte offsets of features
+// relative to the start of the features section. Reading stops at the
+// end of the index, so r is left positioned at the first feature.
+func ReadFlatGeobufIndex(r io.Reader) (*RTree, error) {
+	var prefix [flatgeobufMagicSize + 4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	if string(prefix[0:3]) != "fgb" || prefix[3] != 3 || string(prefix[4:7]) != "fgb" {
+		return nil, errFlatGeobuf
+	}
+
+	headerSize := binary.LittleEndian.Uint32(prefix[flatgeobufMagicSize:])
+	if headerSize > 10*1024*1024 {
+		return nil, errFlatGeobuf
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	// absent fields take the schema defaults
+	featuresCount, ok := flatbuffersField(header, flatgeobufFeaturesCount, 8, 0)
+	if !ok {
+		return nil, errFlatGeobuf
+	}
+
+	nodeSize, ok := flatbuffersField(header, flatgeobufIndexNodeSize, 2, 16)
+	if !ok {
+		return nil, errFlatGeobuf
+	}
+
+	if featuresCount == 0 {
+		return &RTree{}, nil
+	}
+	if nodeSize == 0 {
+		return nil, errors.New("flatrtree: FlatGeobuf file has no index")
+	}
+	if nodeSize < 2 || featuresCount > math.MaxUint32 {
+		return nil, errFlatGeobuf
+	}
+
+	count := int(featuresCount)
+	levelSizes := packedLevelSizes(count, int(nodeSize))
+	numNodes := packedNumNodes(levelSizes)
+
+	// Nodes are read into buffers that grow as they are read, since the
+	// count comes from the header and a short file must not allocate
+	// for every node it declares.
+	initial := numNodes
+	if initial > streamBufferSize {
+		initial = streamBufferSize
+	}
+	fgbBoxes := make([]float64, 0, 4*initial)
+	fgbOffsets := make([]uint64, 0, initial)
+
+	buf := make([]byte, flatgeobufNodeSize)
+	for i := 0; i < numNodes; i++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, noEOF(err)
+		}
+
+		for j := 0; j < 4; j++ {
+			fgbBoxes = append(fgbBoxes, math.Float64frombits(binary.LittleEndian.Uint64(buf[j*8:])))
+		}
+
+		offset := binary.LittleEndian.Uint64(buf[32:])
+		if offset > math.MaxInt64 {
+			return nil, errFlatGeobuf
+		}
+		fgbOffsets = append(fgbOffsets, offset)
+	}
+
+	// place each level, stored from the root down, where it
+	// belongs in the bottom-up layout
+	index := &RTree{
+		count: count,
+		refs:  make([]int64, numNodes+1),
+		boxes: make([]float64, numNodes*4),
+	}
+
+	// firstChild holds the node offset of the first child
+	// of each node, converted to positions once all are placed
+	firstChild := make([]int64, numNodes)
+
+	fgbStart := make([]int, len(levelSizes))
+	ourStart := make([]int, len(levelSizes))
+	for level, fgb := len(levelSizes)-1, 0; level >= 0; level-- {
+		fgbStart[level] = fgb
+		fgb += levelSizes[level]
+	}
+	for level, ours := 0, 0; level < len(levelSizes); level++ {
+		ourStart[level] = ours
+		ours += levelSizes[level]
+	}
+
+	for level := len(levelSizes) - 1; level >= 0; level-- {
+		from, to := fgbStart[level], ourStart[level]
+		copy(index.boxes[to*4:], fgbBoxes[from*4:(from+levelSizes[level])*4])
+
+		for i := 0; i < levelSizes[level]; i++ {
+			offset := fgbOffsets[from+i]
+			pos := to + i
+
+			if level == 0 {
+				index.refs[pos] = int64(offset)
+			} else {
+				// convert the child's node offset to its bottom-up position
+				child := int64(offset) - int64(fgbStart[level-1])
+				if child < 0 || child >= int64(levelSizes[level-1]) {
+					return nil, errFlatGeobuf
+				}
+				firstChild[pos] = int64(ourStart[level-1]) + child
+			}
+		}
+	}
+
+	// every node must start where the implied layout says it does
+	nodeRefs := packedNodeRefs(levelSizes, int(nodeSize))
+	for i, ref := range nodeRefs[:len(nodeRefs)-1] {
+		if firstChild[count+i]*4 != ref {
+			return nil, errFlatGeobuf
+		}
+	}
+	copy(index.refs[count:], nodeRefs)
+
+	return index, nil
+}
+
+// flatbuffersField reads a scalar field of the root table of a
+// FlatBuffers buffer, returning def if the field is absent.
+func flatbuffersField(b []byte, field, size int, def uint64) (uint64, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+
+	table := int64(binary.LittleEndian.Uint32(b))
+	if table+4 > int64(len(b)) {
+		return 0, false
+	}
+
+	vtable := table - int64(int32(binary.LittleEndian.Uint32(b[table:])))
+	if vtable < 0 || vtable+4 > int64(len(b)) {
+		return 0, false
+	}
+
+	vtableSize := int64(binary.LittleEndian.Uint16(b[vtable:]))
+	entry := 4 + 2*int64(field)
+	if entry+2 > vtableSize {
+		return def, true
+	}
+	if vtable+entry+2 > int64(len(b)) {
+		return 0, false
+	}
+
+	offset := int64(binary.LittleEndian.Uint16(b[vtable+entry:]))
+	if offset == 0 {
+		return def, true
+	}
+
+	pos := table + offset
+	if pos+int64(size) > int64(len(b)) {
+		return 0, false
+	}
+
+	switch size {
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(b[pos:])), true
+	default:
+		return binary.LittleEndian.Uint64(b[pos:]), true
+	}
+}
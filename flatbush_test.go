@@ -0,0 +1,292 @@
+package flatrtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatbushRoundTrip(t *testing.T) {
+	for _, degree := range testDegrees {
+		for _, count := range []int{1, degree, 100} {
+			index, _ := createIndex(t, testBuilders["Hilbert"], count, degree)
+
+			data, err := ExportFlatbush(index)
+			require.Nil(t, err)
+			require.Equal(t, byte(0xfb), data[0])
+			require.Equal(t, byte(0x38), data[1])
+
+			imported, err := ImportFlatbush(data)
+			require.Nil(t, err)
+			require.Equal(t, index.count, imported.count)
+			require.Equal(t, index.boxes, imported.boxes)
+
+			// a root without a full set of children does
+			// not record the node size it was built with
+			if count > degree {
+				require.Equal(t, index.refs, imported.refs)
+			}
+
+			for i := 0; i < len(index.boxes); i += 4 {
+				var expected, actual []int64
+				index.Search(index.boxes[i], index.boxes[i+1], index.boxes[i+2], index.boxes[i+3], func(ref int64) bool {
+					expected = append(expected, ref)
+					return true
+				})
+				imported.Search(index.boxes[i], index.boxes[i+1], index.boxes[i+2], index.boxes[i+3], func(ref int64) bool {
+					actual = append(actual, ref)
+					return true
+				})
+				require.ElementsMatch(t, expected, actual)
+			}
+		}
+	}
+}
+
+func TestExportFlatbushLayout(t *testing.T) {
+	// the first node has one child and the second two
+	index := &RTree{
+		count: 3,
+		refs:  []int64{0, 1, 2, 0, 4, 12, 20},
+		boxes: []float64{
+			0, 0, 1, 1,
+			2, 2, 3, 3,
+			4, 4, 5, 5,
+			0, 0, 1, 1,
+			2, 2, 5, 5,
+			0, 0, 5, 5,
+		},
+	}
+
+	_, err := ExportFlatbush(index)
+	require.Equal(t, errFlatbushLayout, err)
+
+	_, err = ExportFlatbush(&RTree{})
+	require.Error(t, err)
+}
+
+// flatbushFixture is what Flatbush writes for three items added as
+// (2, 2, 3, 3), (10, 10, 11, 11) and (0, 0, 1, 1) with a node size
+// of 2 and Int16Array coordinates.
+func flatbushFixture() []byte {
+	var b []byte
+	b = append(b, 0xfb, 0x33)
+	b = appendUint16(b, 2)
+	b = appendUint32(b, 3)
+	for _, coord := range []int16{
+		0, 0, 1, 1,
+		2, 2, 3, 3,
+		10, 10, 11, 11,
+		0, 0, 3, 3,
+		10, 10, 11, 11,
+		0, 0, 11, 11,
+	} {
+		b = appendUint16(b, uint16(coord))
+	}
+	for _, index := range []uint16{2, 0, 1, 0, 8, 12} {
+		b = appendUint16(b, index)
+	}
+	return b
+}
+
+func TestImportFlatbushFixture(t *testing.T) {
+	index, err := ImportFlatbush(flatbushFixture())
+	require.Nil(t, err)
+
+	require.Equal(t, 3, index.Count())
+	require.Equal(t, []int64{2, 0, 1, 0, 8, 12, 20}, index.refs)
+	require.Equal(t, []float64{0, 0, 11, 11}, index.boxes[20:])
+
+	var refs []int64
+	index.Search(0.5, 0.5, 2.5, 2.5, func(ref int64) bool {
+		refs = append(refs, ref)
+		return true
+	})
+	require.Equal(t, []int64{2, 0}, refs)
+}
+
+func TestImportFlatbushInvalid(t *testing.T) {
+	fixture := flatbushFixture()
+
+	for name, mutate := range map[string]func(b []byte) []byte{
+		"magic":      func(b []byte) []byte { b[0] = 0; return b },
+		"version":    func(b []byte) []byte { b[1] = 0x23; return b },
+		"array type": func(b []byte) []byte { b[1] = 0x39; return b },
+		"no items":   func(b []byte) []byte { binary.LittleEndian.PutUint32(b[4:], 0); return b },
+		"too many":   func(b []byte) []byte { binary.LittleEndian.PutUint32(b[4:], math.MaxUint32); return b },
+		"truncated":  func(b []byte) []byte { return b[:len(b)-1] },
+		"node index": func(b []byte) []byte { b[len(b)-2] = 4; return b },
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := mutate(append([]byte(nil), fixture...))
+			_, err := ImportFlatbush(b)
+			require.Equal(t, errFlatbushFormat, err)
+		})
+	}
+}
+
+// writeFlatGeobuf writes the start of a FlatGeobuf file with the
+// index in the packed layout, using the item refs as feature offsets.
+func writeFlatGeobuf(index *RTree, nodeSize int) []byte {
+	var b []byte
+	b = append(b, "fgb\x03fgb\x01"...)
+
+	// a header table with only features_count and index_node_size set
+	var header []byte
+	header = appendUint32(header, 28)
+	header = appendUint16(header, 24)
+	header = appendUint16(header, 14)
+	for field := 0; field < 10; field++ {
+		switch field {
+		case flatgeobufFeaturesCount:
+			header = appendUint16(header, 4)
+		case flatgeobufIndexNodeSize:
+			header = appendUint16(header, 12)
+		default:
+			header = appendUint16(header, 0)
+		}
+	}
+	header = appendUint32(header, 24)
+	header = appendUint64(header, uint64(index.count))
+	header = appendUint16(header, uint16(nodeSize))
+	header = append(header, 0, 0)
+
+	b = appendUint32(b, uint32(len(header)))
+	b = append(b, header...)
+
+	if index.count == 0 {
+		return b
+	}
+
+	levelSizes := packedLevelSizes(index.count, nodeSize)
+
+	// levels are written from the root down
+	start := make([]int, len(levelSizes))
+	for level, pos := 0, 0; level < len(levelSizes); level++ {
+		start[level] = pos
+		pos += levelSizes[level]
+	}
+	fgbStart := make([]int, len(levelSizes))
+	for level, pos := len(levelSizes)-1, 0; level >= 0; level-- {
+		fgbStart[level] = pos
+		pos += levelSizes[level]
+	}
+
+	for level := len(levelSizes) - 1; level >= 0; level-- {
+		for pos := start[level]; pos < start[level]+levelSizes[level]; pos++ {
+			for _, coord := range index.boxes[pos*4 : pos*4+4] {
+				b = appendUint64(b, math.Float64bits(coord))
+			}
+			if level == 0 {
+				b = appendUint64(b, uint64(index.refs[pos]))
+			} else {
+				child := int(index.refs[pos]/4) - start[level-1] + fgbStart[level-1]
+				b = appendUint64(b, uint64(child))
+			}
+		}
+	}
+
+	return b
+}
+
+func TestReadFlatGeobufIndex(t *testing.T) {
+	for _, degree := range testDegrees {
+		for _, count := range []int{0, 1, degree, 100} {
+			index, items := createIndex(t, testBuilders["Hilbert"], count, degree)
+			data := append(writeFlatGeobuf(index, degree), "features"...)
+
+			r := bytes.NewReader(data)
+			imported, err := ReadFlatGeobufIndex(r)
+			require.Nil(t, err)
+			require.Equal(t, index.count, imported.count)
+
+			// the reader is left at the features
+			rest, err := io.ReadAll(r)
+			require.Nil(t, err)
+			require.Equal(t, "features", string(rest))
+
+			if count == 0 {
+				continue
+			}
+			require.Equal(t, index.boxes, imported.boxes)
+
+			for i := 0; i < count; i++ {
+				var expected, actual []int64
+				index.Search(items[i*4], items[i*4+1], items[i*4+2], items[i*4+3], func(ref int64) bool {
+					expected = append(expected, ref)
+					return true
+				})
+				imported.Search(items[i*4], items[i*4+1], items[i*4+2], items[i*4+3], func(ref int64) bool {
+					actual = append(actual, ref)
+					return true
+				})
+				require.Equal(t, expected, actual)
+			}
+		}
+	}
+}
+
+func TestReadFlatGeobufIndexNoFeaturesCount(t *testing.T) {
+	// features_count defaults to 0 when absent from the header
+	data := writeFlatGeobuf(&RTree{}, 16)
+	binary.LittleEndian.PutUint16(data[12+4+4+2*flatgeobufFeaturesCount:], 0)
+	data = append(data, "features"...)
+
+	r := bytes.NewReader(data)
+	imported, err := ReadFlatGeobufIndex(r)
+	require.Nil(t, err)
+	require.Equal(t, 0, imported.Count())
+
+	rest, err := io.ReadAll(r)
+	require.Nil(t, err)
+	require.Equal(t, "features", string(rest))
+}
+
+func TestReadFlatGeobufIndexHugeCount(t *testing.T) {
+	// a truncated file must not allocate for every node it declares
+	data := writeFlatGeobuf(&RTree{}, 16)
+	binary.LittleEndian.PutUint64(data[12+28+4:], math.MaxUint32)
+	data = append(data, make([]byte, flatgeobufNodeSize)...)
+
+	_, err := ReadFlatGeobufIndex(bytes.NewReader(data))
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadFlatGeobufIndexInvalid(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, 5)
+	data := writeFlatGeobuf(index, 5)
+
+	_, err := ReadFlatGeobufIndex(bytes.NewReader([]byte("fgb\x02fgb\x00\x00\x00\x00\x00")))
+	require.Equal(t, errFlatGeobuf, err)
+
+	_, err = ReadFlatGeobufIndex(bytes.NewReader(data[:len(data)-1]))
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// no index
+	noIndex := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint16(noIndex[12+28+12:], 0)
+	_, err = ReadFlatGeobufIndex(bytes.NewReader(noIndex))
+	require.Error(t, err)
+
+	// the root must point at the first node of the level below
+	badChild := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(badChild[12+44+32:], 2)
+	_, err = ReadFlatGeobufIndex(bytes.NewReader(badChild))
+	require.Equal(t, errFlatGeobuf, err)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return appendUint16(appendUint16(b, uint16(v)), uint16(v>>16))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
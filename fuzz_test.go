@@ -0,0 +1,176 @@
+package flatrtree
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+// addFuzzIndexes seeds f with serialized indexes of a few sizes.
+func addFuzzIndexes(f *testing.F) {
+	for _, count := range []int{0, 1, 5, 30} {
+		index, _ := createIndex(f, testBuilders["Hilbert"], count, 4)
+		data, err := Serialize(index, 1)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+// fuzzQueries runs searches and neighbor queries on a decoded index.
+// If complete, every item must be found; otherwise the queries only
+// have to return, since an unvalidated index may hide items.
+func fuzzQueries(t *testing.T, index *RTree, complete bool) {
+	if index.Count() == 0 {
+		return
+	}
+
+	root := index.boxes[len(index.boxes)-4:]
+	queries := [][4]float64{
+		{root[0], root[1], root[2], root[3]},
+		{root[0], root[1], root[0], root[1]},
+		{math.Inf(-1), math.Inf(-1), math.Inf(1), math.Inf(1)},
+	}
+
+	for _, q := range queries {
+		found := 0
+		index.Search(q[0], q[1], q[2], q[3], func(ref int64) bool {
+			found++
+			return true
+		})
+		if complete && q == queries[2] && found != index.Count() {
+			t.Fatalf("found %d of %d items", found, index.Count())
+		}
+
+		found = 0
+		index.Neighbors(q[0], q[1], func(ref int64, dist float64) bool {
+			found++
+			return true
+		}, PlanarBoxDist, nil)
+		if complete && found != index.Count() {
+			t.Fatalf("visited %d of %d neighbors", found, index.Count())
+		}
+	}
+}
+
+func FuzzDeserialize(f *testing.F) {
+	addFuzzIndexes(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		index, err := Deserialize(data)
+		if err != nil {
+			return
+		}
+		fuzzQueries(t, index, false)
+	})
+}
+
+func FuzzDeserializeValidate(f *testing.F) {
+	addFuzzIndexes(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		index, err := DeserializeWithOptions(data, DeserializeOptions{Validate: true})
+		if err != nil {
+			return
+		}
+		fuzzQueries(t, index, true)
+	})
+}
+
+// fuzzBoxes decodes every 4 bytes as a small box
+// so the fuzzer can easily produce overlaps.
+func fuzzBoxes(data []byte) []float64 {
+	boxes := make([]float64, 0, len(data)/4*4)
+	for i := 0; i+4 <= len(data); i += 4 {
+		x := float64(int8(data[i]))
+		y := float64(int8(data[i+1]))
+		boxes = append(boxes, x, y, x+float64(data[i+2]%16), y+float64(data[i+3]%16))
+	}
+	return boxes
+}
+
+func FuzzBuilders(f *testing.F) {
+	f.Add([]byte{}, uint8(0))
+	f.Add([]byte{0, 0, 1, 1}, uint8(8))
+	f.Add([]byte{1, 2, 3, 4, 200, 100, 5, 0, 1, 2, 3, 4, 7, 7, 0, 0, 250, 10, 15, 15}, uint8(0))
+
+	f.Fuzz(func(t *testing.T, data []byte, degree uint8) {
+		items := fuzzBoxes(data)
+		count := len(items) / 4
+
+		for name, newBuilder := range testBuilders {
+			builder := newBuilder()
+			for i := 0; i < count; i++ {
+				builder.Add(int64(i), items[i*4], items[i*4+1], items[i*4+2], items[i*4+3])
+			}
+
+			index, err := builder.Finish(2 + int(degree%15))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := index.Validate(); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			for i := 0; i < count; i++ {
+				minX, minY := items[i*4]-1, items[i*4+1]-1
+				maxX, maxY := items[i*4+2]+1, items[i*4+3]+1
+
+				var expected, actual []int64
+				for j := 0; j < count; j++ {
+					if !(maxX < items[j*4] || maxY < items[j*4+1] || minX > items[j*4+2] || minY > items[j*4+3]) {
+						expected = append(expected, int64(j))
+					}
+				}
+				index.Search(minX, minY, maxX, maxY, func(ref int64) bool {
+					actual = append(actual, ref)
+					return true
+				})
+				sort.Slice(actual, func(a, b int) bool { return actual[a] < actual[b] })
+				if !equalInt64s(expected, actual) {
+					t.Fatalf("%s: search %d: expected %v, got %v", name, i, expected, actual)
+				}
+
+				var expectedDists, actualDists []float64
+				for j := 0; j < count; j++ {
+					expectedDists = append(expectedDists, PlanarBoxDist(
+						minX, minY, items[j*4], items[j*4+1], items[j*4+2], items[j*4+3],
+					))
+				}
+				sort.Float64s(expectedDists)
+				index.Neighbors(minX, minY, func(ref int64, dist float64) bool {
+					actualDists = append(actualDists, dist)
+					return true
+				}, PlanarBoxDist, nil)
+				if !equalFloat64s(expectedDists, actualDists) {
+					t.Fatalf("%s: neighbors %d: expected %v, got %v", name, i, expectedDists, actualDists)
+				}
+			}
+		}
+	})
+}
+
+func equalInt64s(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalFloat64s(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
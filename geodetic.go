@@ -0,0 +1,77 @@
+package flatrtree
+
+import "math"
+
+// AddGeodetic adds an item with a lon/lat box to the builder. Longitudes
+// are normalized to [-180, 180]. A box with minLon > maxLon crosses the
+// antimeridian and is added as two boxes with the same ref, one on each
+// side. Count, Neighbors, KNN and the join functions see both boxes and
+// may report the ref twice; SearchGeodetic reports each ref once.
+func AddGeodetic(b Builder, ref int64, minLon, minLat, maxLon, maxLat float64) {
+	for _, box := range splitLonRange(minLon, maxLon) {
+		b.Add(ref, box[0], minLat, box[1], maxLat)
+	}
+}
+
+// SearchGeodetic calls the iterf function once for each item
+// intersecting the lon/lat search box. If iterf returns false the
+// search will terminate.
+//
+// Longitudes are normalized to [-180, 180] and a box with
+// minLon > maxLon is treated as crossing the antimeridian.
+func (r *RTree) SearchGeodetic(
+	minLon, minLat, maxLon, maxLat float64,
+	iterf func(ref int64) (next bool),
+) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	// Items crossing the antimeridian are indexed as two boxes, which a
+	// query can find separately whether or not it is split itself.
+	var seen map[int64]struct{}
+	next := true
+	for _, box := range splitLonRange(minLon, maxLon) {
+		r.Search(box[0], minLat, box[1], maxLat, func(ref int64) bool {
+			if _, ok := seen[ref]; ok {
+				return true
+			}
+			if seen == nil {
+				seen = make(map[int64]struct{})
+			}
+			seen[ref] = struct{}{}
+			next = iterf(ref)
+			return next
+		})
+		if !next {
+			return
+		}
+	}
+}
+
+// splitLonRange normalizes a longitude range and
+// splits it in two if it crosses the antimeridian.
+func splitLonRange(minLon, maxLon float64) [][2]float64 {
+	if maxLon-minLon >= 360 {
+		return [][2]float64{{-180, 180}}
+	}
+
+	minLon, maxLon = normalizeLon(minLon), normalizeLon(maxLon)
+	if minLon <= maxLon {
+		return [][2]float64{{minLon, maxLon}}
+	}
+
+	return [][2]float64{{minLon, 180}, {-180, maxLon}}
+}
+
+// normalizeLon wraps a longitude to [-180, 180]
+func normalizeLon(lon float64) float64 {
+	if lon >= -180 && lon <= 180 {
+		return lon
+	}
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
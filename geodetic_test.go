@@ -0,0 +1,129 @@
+package flatrtree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLon(t *testing.T) {
+	require.Equal(t, 0.0, normalizeLon(0))
+	require.Equal(t, 180.0, normalizeLon(180))
+	require.Equal(t, -180.0, normalizeLon(-180))
+	require.Equal(t, -170.0, normalizeLon(190))
+	require.Equal(t, 170.0, normalizeLon(-190))
+	require.Equal(t, 10.0, normalizeLon(730))
+}
+
+func TestSplitLonRange(t *testing.T) {
+	require.Equal(t, [][2]float64{{-10, 10}}, splitLonRange(-10, 10))
+	require.Equal(t, [][2]float64{{170, 180}, {-180, -170}}, splitLonRange(170, -170))
+	require.Equal(t, [][2]float64{{170, 180}, {-180, -170}}, splitLonRange(170, 190))
+	require.Equal(t, [][2]float64{{-180, 180}}, splitLonRange(-200, 200))
+}
+
+func geodeticIntersects(a, b [4]float64) bool {
+	if a[3] < b[1] || a[1] > b[3] {
+		return false
+	}
+	for _, x := range splitLonRange(a[0], a[2]) {
+		for _, y := range splitLonRange(b[0], b[2]) {
+			if !(x[1] < y[0] || x[0] > y[1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestSearchGeodetic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	randomBox := func(maxWidth float64) [4]float64 {
+		// centered near the antimeridian half the time
+		lon := rng.Float64()*360 - 180
+		if rng.Intn(2) == 0 {
+			lon = 180 + rng.Float64()*10 - 5
+		}
+		lat := rng.Float64()*160 - 80
+		w, h := rng.Float64()*maxWidth, rng.Float64()*maxWidth
+		return [4]float64{normalizeLon(lon - w/2), lat - h/2, normalizeLon(lon + w/2), lat + h/2}
+	}
+
+	items := make([][4]float64, 1000)
+	for i := range items {
+		items[i] = randomBox(4)
+	}
+
+	for builderName, newBuilder := range testBuilders {
+		builder := newBuilder()
+		for i, box := range items {
+			AddGeodetic(builder, int64(i), box[0], box[1], box[2], box[3])
+		}
+		index, err := builder.Finish(DefaultDegree)
+		require.Nil(t, err)
+
+		// wide queries are not split but can reach both sides
+		queries := [][4]float64{
+			{-179, -90, 179, 90},
+			{-180, -90, 179, 90},
+			{-179, -90, 180, 90},
+			{-180, -90, 180, 90},
+		}
+		for q := 0; q < 200; q++ {
+			queries = append(queries, randomBox(20))
+		}
+
+		for _, query := range queries {
+
+			var expected []int64
+			for i, box := range items {
+				if geodeticIntersects(query, box) {
+					expected = append(expected, int64(i))
+				}
+			}
+
+			var actual []int64
+			index.SearchGeodetic(query[0], query[1], query[2], query[3], func(ref int64) bool {
+				actual = append(actual, ref)
+				return true
+			})
+			sort.Slice(actual, func(i, j int) bool { return actual[i] < actual[j] })
+
+			require.Equal(t, expected, actual, builderName)
+		}
+	}
+}
+
+func TestSearchGeodeticEarlyTermination(t *testing.T) {
+	builder := NewHilbertBuilder()
+	for i := 0; i < 10; i++ {
+		AddGeodetic(builder, int64(i), 175, 0, -175, 1)
+	}
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+	require.Equal(t, 20, index.Count())
+
+	n := 0
+	index.SearchGeodetic(170, 0, -170, 1, func(ref int64) bool {
+		n++
+		return true
+	})
+	require.Equal(t, 10, n)
+
+	n = 0
+	index.SearchGeodetic(-180, 0, 180, 1, func(ref int64) bool {
+		n++
+		return true
+	})
+	require.Equal(t, 10, n)
+
+	n = 0
+	index.SearchGeodetic(170, 0, -170, 1, func(ref int64) bool {
+		n++
+		return false
+	})
+	require.Equal(t, 1, n)
+}
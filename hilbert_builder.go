@@ -1,27 +1,62 @@
 package flatrtree
 
 import (
-	"errors"
-	"fmt"
 	"math"
 	"sort"
+	"sync"
 )
 
 var _ Builder = &HilbertBuilder{}
 
+// HilbertOptions configures a HilbertBuilder.
+type HilbertOptions struct {
+	// Bits is the resolution of the Hilbert curve per axis, 16 or 32.
+	// Box centers are mapped to a grid of 2^Bits cells per axis over
+	// the extent of all boxes, so items closer than a cell share a
+	// position on the curve. 32 bits keeps small clusters in a large
+	// extent apart, at the cost of 64-bit sort keys. Defaults to 16.
+	Bits int
+
+	// Parallelism is the number of goroutines used to compute Hilbert
+	// values and sort items. Values below 2 sort items in place on the
+	// calling goroutine, ordering them as earlier releases did. Above 1,
+	// items with equal Hilbert values keep the order they were added in,
+	// which costs 16 bytes per item for sort keys and a copy of the
+	// items. The index is then the same for any Parallelism above 1, but
+	// can differ from a serial build in the order of items with equal
+	// Hilbert values.
+	Parallelism int
+}
+
 type HilbertBuilder struct {
 	count                  int
 	refs                   []int64
 	boxes                  []float64
 	minX, minY, maxX, maxY float64
+	opts                   HilbertOptions
+
+	// buffers kept from the previous Finish for reuse after Reset
+	values32   []uint32
+	values64   []uint64
+	keys       []hilbertKey
+	spareRefs  []int64
+	spareBoxes []float64
 }
 
 func NewHilbertBuilder() *HilbertBuilder {
+	return NewHilbertBuilderWithOptions(HilbertOptions{})
+}
+
+func NewHilbertBuilderWithOptions(opts HilbertOptions) *HilbertBuilder {
+	if opts.Bits == 0 {
+		opts.Bits = 16
+	}
 	return &HilbertBuilder{
 		minX: math.Inf(1),
 		minY: math.Inf(1),
 		maxX: math.Inf(-1),
 		maxY: math.Inf(-1),
+		opts: opts,
 	}
 }
 
@@ -35,21 +70,83 @@ func (b *HilbertBuilder) Add(ref int64, minX, minY, maxX, maxY float64) {
 	b.maxY = math.Max(b.maxY, maxY)
 }
 
+// AddBoxes adds items with refs[i] and the box at boxes[i*4:i*4+4].
+// It panics if len(boxes) != 4*len(refs).
+func (b *HilbertBuilder) AddBoxes(refs []int64, boxes []float64) {
+	if len(boxes) != 4*len(refs) {
+		panic("len(boxes) != 4*len(refs)")
+	}
+
+	b.count += len(refs)
+	b.refs = append(b.refs, refs...)
+	b.boxes = append(b.boxes, boxes...)
+	for i := 0; i < len(boxes); i += 4 {
+		b.minX = math.Min(b.minX, boxes[i])
+		b.minY = math.Min(b.minY, boxes[i+1])
+		b.maxX = math.Max(b.maxX, boxes[i+2])
+		b.maxY = math.Max(b.maxY, boxes[i+3])
+	}
+}
+
+// Grow grows the builder's capacity to add n more items without allocating.
+func (b *HilbertBuilder) Grow(n int) {
+	b.refs = growSlice(b.refs, n)
+	b.boxes = growSlice(b.boxes, 4*n)
+}
+
+// Reset removes all items so the builder can be reused, keeping its
+// buffers. An index returned by a previous Finish shares these buffers
+// and must not be used after Reset.
+func (b *HilbertBuilder) Reset() {
+	b.count = 0
+	b.refs = b.refs[:0]
+	b.boxes = b.boxes[:0]
+	b.minX = math.Inf(1)
+	b.minY = math.Inf(1)
+	b.maxX = math.Inf(-1)
+	b.maxY = math.Inf(-1)
+}
+
 func (b *HilbertBuilder) Finish(degree int) (*RTree, error) {
 	if degree < 2 {
-		return nil, fmt.Errorf("degree < 2")
+		return nil, ErrInvalidDegree
+	}
+
+	return b.FinishWithOptions(BuildOptions{LeafDegree: degree, NodeDegree: degree})
+}
+
+// FinishWithOptions is Finish with separate degrees for leaves and the
+// nodes above them.
+func (b *HilbertBuilder) FinishWithOptions(opts BuildOptions) (*RTree, error) {
+	if err := checkHilbertBits(b.opts.Bits); err != nil {
+		return nil, err
+	}
+
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
 	}
 
 	if b.count == 0 {
 		return &RTree{}, nil
 	}
 
+	if err := checkCount(b.count); err != nil {
+		return nil, err
+	}
+
 	if len(b.refs) != b.count {
-		return nil, errors.New("Finish called more than once")
+		return nil, ErrFinishCalledTwice
+	}
+
+	if opts.Strict {
+		if err := checkBoxes(b.refs, b.boxes); err != nil {
+			return nil, err
+		}
 	}
 
-	b.sort()
-	b.pack(degree)
+	b.sort(opts.numBoxes(b.count))
+	b.pack(opts)
 
 	return &RTree{
 		count: b.count,
@@ -58,49 +155,82 @@ func (b *HilbertBuilder) Finish(degree int) (*RTree, error) {
 	}, nil
 }
 
-func (b *HilbertBuilder) sort() {
-	const hilbertMax float64 = (1 << 16) - 1
-
-	var (
-		xScale, yScale float64
-		midX, midY     float64
-		x, y           uint32
-	)
+// sort orders items along the Hilbert curve into buffers
+// with capacity for numBoxes items and nodes.
+func (b *HilbertBuilder) sort(numBoxes int) {
+	curve := newHilbertCurve(b.opts.Bits, b.minX, b.minY, b.maxX, b.maxY)
 
-	width := b.maxX - b.minX
-	if width > 0 {
-		xScale = hilbertMax / width
+	if b.opts.Parallelism > 1 {
+		b.sortParallel(curve, numBoxes)
+		return
 	}
 
-	height := b.maxY - b.minY
-	if height > 0 {
-		yScale = hilbertMax / height
+	if b.opts.Bits == 32 {
+		b.values64 = reuseSlice(b.values64, b.count)
+		sortByCurve(curve, b.refs, b.boxes, b.values64)
+	} else {
+		b.values32 = reuseSlice(b.values32, b.count)
+		sortByCurve(curve, b.refs, b.boxes, b.values32)
 	}
 
-	hilbertValues := make([]uint32, b.count)
-	for i := 0; i < b.count; i++ {
-		midX = (b.boxes[i*4] + b.boxes[i*4+2]) / 2
-		midY = (b.boxes[i*4+1] + b.boxes[i*4+3]) / 2
-		x = uint32(math.Round(xScale * (midX - b.minX)))
-		y = uint32(math.Round(yScale * (midY - b.minY)))
-		hilbertValues[i] = hilbert(x, y)
+	b.refs = growSlice(b.refs, numBoxes+1-b.count)
+	b.boxes = growSlice(b.boxes, (numBoxes-b.count)*4)
+}
+
+// sortByCurve sorts refs and boxes in place by their Hilbert values
+func sortByCurve[V uint32 | uint64](curve hilbertCurve, refs []int64, boxes []float64, values []V) {
+	for i := range values {
+		values[i] = V(curve.value(boxes[i*4], boxes[i*4+1], boxes[i*4+2], boxes[i*4+3]))
 	}
 
-	sort.Sort(sortByValues{
-		boxes:  b.boxes,
-		refs:   b.refs,
-		values: hilbertValues,
+	sort.Sort(sortByValues[V]{
+		refs:   refs,
+		boxes:  boxes,
+		values: values,
 	})
 }
 
-func (b *HilbertBuilder) pack(degree int) {
+// sortParallel sorts keys of Hilbert values and insertion order on
+// several goroutines, then copies items into sorted order.
+func (b *HilbertBuilder) sortParallel(curve hilbertCurve, numBoxes int) {
+	keys := reuseSlice(b.keys, b.count)
+	parallelRanges(b.count, b.opts.Parallelism, func(start, end int) {
+		for i := start; i < end; i++ {
+			keys[i] = hilbertKey{
+				value: curve.value(b.boxes[i*4], b.boxes[i*4+1], b.boxes[i*4+2], b.boxes[i*4+3]),
+				index: i,
+			}
+		}
+	})
+
+	sortHilbertKeys(keys, b.opts.Parallelism)
+
+	refs := reuseSlice(b.spareRefs, numBoxes+1)[:b.count]
+	boxes := reuseSlice(b.spareBoxes, numBoxes*4)[:b.count*4]
+	parallelRanges(b.count, b.opts.Parallelism, func(start, end int) {
+		for i := start; i < end; i++ {
+			j := keys[i].index
+			refs[i] = b.refs[j]
+			copy(boxes[i*4:i*4+4], b.boxes[j*4:j*4+4])
+		}
+	})
+
+	b.keys = keys
+	b.spareRefs = b.refs
+	b.spareBoxes = b.boxes
+	b.refs = refs
+	b.boxes = boxes
+}
+
+func (b *HilbertBuilder) pack(opts BuildOptions) {
 	count := b.count
 	numNodes := count
 	start, end := int64(0), int64(len(b.boxes))
 	b.refs = append(b.refs, start)
 
 	var nodeMinX, nodeMinY, nodeMaxX, nodeMaxY float64
-	for {
+	for level := 0; ; level++ {
+		degree := opts.degree(level)
 		for start < end {
 			nodeMinX = math.Inf(1)
 			nodeMinY = math.Inf(1)
@@ -126,6 +256,42 @@ func (b *HilbertBuilder) pack(degree int) {
 	}
 }
 
+// hilbertCurve maps box centers within an extent to Hilbert values
+type hilbertCurve struct {
+	bits           int
+	minX, minY     float64
+	xScale, yScale float64
+}
+
+func newHilbertCurve(bits int, minX, minY, maxX, maxY float64) hilbertCurve {
+	hilbertMax := float64(uint64(1)<<bits - 1)
+
+	curve := hilbertCurve{bits: bits, minX: minX, minY: minY}
+
+	width := maxX - minX
+	if width > 0 {
+		curve.xScale = hilbertMax / width
+	}
+
+	height := maxY - minY
+	if height > 0 {
+		curve.yScale = hilbertMax / height
+	}
+
+	return curve
+}
+
+func (c hilbertCurve) value(minX, minY, maxX, maxY float64) uint64 {
+	midX := (minX + maxX) / 2
+	midY := (minY + maxY) / 2
+	x := uint32(math.Round(c.xScale * (midX - c.minX)))
+	y := uint32(math.Round(c.yScale * (midY - c.minY)))
+	if c.bits == 32 {
+		return hilbert64(x, y)
+	}
+	return uint64(hilbert(x, y))
+}
+
 // Based on public domain code at https://github.com/rawrunprotected/hilbert_curves
 func hilbert(x, y uint32) uint32 {
 	a := x ^ y
@@ -182,21 +348,75 @@ func hilbert(x, y uint32) uint32 {
 	return (i1 << 1) | i0
 }
 
-type sortByValues struct {
+// hilbert64 is hilbert extended to 32 bits per axis. The top 32 bits of
+// hilbert64(x<<16, y<<16) equal hilbert(x, y).
+func hilbert64(x, y uint32) uint64 {
+	const mask = 0xFFFFFFFF
+
+	X, Y := uint64(x), uint64(y)
+
+	a := X ^ Y
+	b := mask ^ a
+	c := mask ^ (X | Y)
+	d := X & (Y ^ mask)
+
+	aa := a | (b >> 1)
+	bb := (a >> 1) ^ a
+	cc := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	dd := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	for _, shift := range [...]uint{2, 4, 8} {
+		a = aa
+		b = bb
+		c = cc
+		d = dd
+		aa = (a & (a >> shift)) ^ (b & (b >> shift))
+		bb = (a & (b >> shift)) ^ (b & ((a ^ b) >> shift))
+		cc ^= (a & (c >> shift)) ^ (b & (d >> shift))
+		dd ^= (b & (c >> shift)) ^ ((a ^ b) & (d >> shift))
+	}
+
+	a = aa
+	b = bb
+	c = cc
+	d = dd
+	cc ^= (a & (c >> 16)) ^ (b & (d >> 16))
+	dd ^= (b & (c >> 16)) ^ ((a ^ b) & (d >> 16))
+
+	a = cc ^ (cc >> 1)
+	b = dd ^ (dd >> 1)
+
+	i0 := X ^ Y
+	i1 := b | (mask ^ (i0 | a))
+
+	return (interleave64(i1) << 1) | interleave64(i0)
+}
+
+// interleave64 spreads the low 32 bits of x to the even bits
+func interleave64(x uint64) uint64 {
+	x = (x | (x << 16)) & 0x0000FFFF0000FFFF
+	x = (x | (x << 8)) & 0x00FF00FF00FF00FF
+	x = (x | (x << 4)) & 0x0F0F0F0F0F0F0F0F
+	x = (x | (x << 2)) & 0x3333333333333333
+	x = (x | (x << 1)) & 0x5555555555555555
+	return x
+}
+
+type sortByValues[V uint32 | uint64] struct {
 	refs   []int64
 	boxes  []float64
-	values []uint32
+	values []V
 }
 
-func (s sortByValues) Len() int {
+func (s sortByValues[V]) Len() int {
 	return len(s.values)
 }
 
-func (s sortByValues) Less(i, j int) bool {
+func (s sortByValues[V]) Less(i, j int) bool {
 	return s.values[i] < s.values[j]
 }
 
-func (s sortByValues) Swap(i, j int) {
+func (s sortByValues[V]) Swap(i, j int) {
 	s.refs[i], s.refs[j] = s.refs[j], s.refs[i]
 
 	s.boxes[i*4], s.boxes[j*4] = s.boxes[j*4], s.boxes[i*4]
@@ -206,3 +426,75 @@ func (s sortByValues) Swap(i, j int) {
 
 	s.values[i], s.values[j] = s.values[j], s.values[i]
 }
+
+// hilbertKey orders an item by Hilbert value, then by the order it was added
+type hilbertKey struct {
+	value uint64
+	index int
+}
+
+func (k hilbertKey) less(other hilbertKey) bool {
+	return k.value < other.value || (k.value == other.value && k.index < other.index)
+}
+
+type hilbertKeys []hilbertKey
+
+func (s hilbertKeys) Len() int           { return len(s) }
+func (s hilbertKeys) Less(i, j int) bool { return s[i].less(s[j]) }
+func (s hilbertKeys) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// sortHilbertKeys sorts ranges of keys concurrently and merges them.
+// Keys are unique, so the result does not depend on parallelism.
+func sortHilbertKeys(keys []hilbertKey, parallelism int) {
+	bounds := splitRanges(len(keys), parallelism)
+	if bounds == nil {
+		sort.Sort(hilbertKeys(keys))
+		return
+	}
+
+	parallelRanges(len(keys), parallelism, func(start, end int) {
+		sort.Sort(hilbertKeys(keys[start:end]))
+	})
+
+	src, dst := keys, make([]hilbertKey, len(keys))
+	for len(bounds) > 2 {
+		var (
+			wg     sync.WaitGroup
+			merged []int
+		)
+		for i := 0; i+1 < len(bounds); i += 2 {
+			merged = append(merged, bounds[i])
+			if i+2 >= len(bounds) {
+				copy(dst[bounds[i]:], src[bounds[i]:bounds[i+1]])
+				continue
+			}
+
+			wg.Add(1)
+			go func(start, mid, end int) {
+				defer wg.Done()
+				mergeHilbertKeys(dst[start:end], src[start:mid], src[mid:end])
+			}(bounds[i], bounds[i+1], bounds[i+2])
+		}
+		wg.Wait()
+
+		bounds = append(merged, len(keys))
+		src, dst = dst, src
+	}
+
+	if &src[0] != &keys[0] {
+		copy(keys, src)
+	}
+}
+
+func mergeHilbertKeys(dst, a, b []hilbertKey) {
+	i, j := 0, 0
+	for k := range dst {
+		if j == len(b) || (i < len(a) && a[i].less(b[j])) {
+			dst[k] = a[i]
+			i++
+		} else {
+			dst[k] = b[j]
+			j++
+		}
+	}
+}
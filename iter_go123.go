@@ -0,0 +1,28 @@
+//go:build go1.23
+
+package flatrtree
+
+import "iter"
+
+// SearchSeq returns an iterator over the refs of all items intersecting
+// the search box. Breaking out of the loop terminates the search.
+func (r *RTree) SearchSeq(minX, minY, maxX, maxY float64) iter.Seq[int64] {
+	return func(yield func(ref int64) bool) {
+		r.Search(minX, minY, maxX, maxY, yield)
+	}
+}
+
+// NeighborsSeq returns an iterator over the refs and distances of all
+// items in ascending order of distance to the given coordinates.
+// Breaking out of the loop terminates the search.
+//
+// See Neighbors for a description of boxDist and itemDist.
+func (r *RTree) NeighborsSeq(
+	x, y float64,
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) iter.Seq2[int64, float64] {
+	return func(yield func(ref int64, dist float64) bool) {
+		r.Neighbors(x, y, yield, boxDist, itemDist)
+	}
+}
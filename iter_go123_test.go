@@ -0,0 +1,79 @@
+//go:build go1.23
+
+package flatrtree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchSeq(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < tc.count; i++ {
+				var expected, actual []int64
+				tc.index.Search(tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3], func(ref int64) bool {
+					expected = append(expected, ref)
+					return true
+				})
+				for ref := range tc.index.SearchSeq(tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3]) {
+					actual = append(actual, ref)
+				}
+				require.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestNeighborsSeq(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			var expected, actual []int64
+			var expectedDists, actualDists []float64
+			tc.index.Neighbors(50, 50, func(ref int64, dist float64) bool {
+				expected = append(expected, ref)
+				expectedDists = append(expectedDists, dist)
+				return true
+			}, PlanarBoxDist, nil)
+			for ref, dist := range tc.index.NeighborsSeq(50, 50, PlanarBoxDist, nil) {
+				actual = append(actual, ref)
+				actualDists = append(actualDists, dist)
+			}
+			require.Equal(t, expected, actual)
+			require.Equal(t, expectedDists, actualDists)
+		})
+	}
+}
+
+func TestSeqBreak(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	n := 0
+	for range index.SearchSeq(0, 0, 100, 100) {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	require.Equal(t, 3, n)
+
+	expected := 0
+	index.Neighbors(50, 50, func(ref int64, dist float64) bool {
+		if dist > 10 {
+			return false
+		}
+		expected++
+		return true
+	}, PlanarBoxDist, nil)
+	require.Greater(t, expected, 0)
+
+	n = 0
+	for _, dist := range index.NeighborsSeq(50, 50, PlanarBoxDist, nil) {
+		if dist > 10 {
+			break
+		}
+		n++
+	}
+	require.Equal(t, expected, n)
+}
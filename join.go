@@ -0,0 +1,110 @@
+package flatrtree
+
+// Join calls the iterf function for every pair of items, one from a and
+// one from b, whose boxes intersect. If iterf returns false the join will
+// terminate. Both trees are descended at once, so only pairs of nodes
+// whose boxes intersect are visited.
+func Join(a, b *RTree, iterf func(refA, refB int64) (next bool)) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if a.count == 0 || b.count == 0 {
+		return
+	}
+
+	j := joiner{a, b, iterf}
+	rootA, rootB := int64(len(a.boxes)-4), int64(len(b.boxes)-4)
+	if j.intersects(rootA, rootB) {
+		j.join(rootA, rootB)
+	}
+}
+
+// SelfJoin calls the iterf function once for every pair of distinct items
+// in the index whose boxes intersect. If iterf returns false the join will
+// terminate.
+func SelfJoin(index *RTree, iterf func(refA, refB int64) (next bool)) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if index.count == 0 {
+		return
+	}
+
+	j := joiner{index, index, iterf}
+	j.selfJoin(int64(len(index.boxes) - 4))
+}
+
+type joiner struct {
+	a, b  *RTree
+	iterf func(refA, refB int64) (next bool)
+}
+
+// join pairs the items below two intersecting nodes, one from each tree
+func (j *joiner) join(nodeA, nodeB int64) bool {
+	refIdxA, refIdxB := nodeA/4, nodeB/4
+	itemA, itemB := refIdxA < int64(j.a.count), refIdxB < int64(j.b.count)
+
+	switch {
+	case itemA && itemB:
+		return j.iterf(j.a.refs[refIdxA], j.b.refs[refIdxB])
+
+	case itemA:
+		for childB := j.b.refs[refIdxB]; childB < j.b.refs[refIdxB+1]; childB += 4 {
+			if j.intersects(nodeA, childB) && !j.join(nodeA, childB) {
+				return false
+			}
+		}
+
+	case itemB:
+		for childA := j.a.refs[refIdxA]; childA < j.a.refs[refIdxA+1]; childA += 4 {
+			if j.intersects(childA, nodeB) && !j.join(childA, nodeB) {
+				return false
+			}
+		}
+
+	default:
+		for childA := j.a.refs[refIdxA]; childA < j.a.refs[refIdxA+1]; childA += 4 {
+			// skip children of a outside node b before pairing
+			if !j.intersects(childA, nodeB) {
+				continue
+			}
+			for childB := j.b.refs[refIdxB]; childB < j.b.refs[refIdxB+1]; childB += 4 {
+				if j.intersects(childA, childB) && !j.join(childA, childB) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// selfJoin pairs the items below a node. Pairs within the same child are
+// found by recursing into it, and pairs across two children by joining
+// them, so each pair is reported once.
+func (j *joiner) selfJoin(node int64) bool {
+	refIdx := node / 4
+	if refIdx < int64(j.a.count) {
+		return true
+	}
+
+	start, end := j.a.refs[refIdx], j.a.refs[refIdx+1]
+	for childA := start; childA < end; childA += 4 {
+		if !j.selfJoin(childA) {
+			return false
+		}
+		for childB := childA + 4; childB < end; childB += 4 {
+			if j.intersects(childA, childB) && !j.join(childA, childB) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (j *joiner) intersects(nodeA, nodeB int64) bool {
+	return boxIntersects(j.a.boxes[nodeA:], j.b.boxes[nodeB], j.b.boxes[nodeB+1], j.b.boxes[nodeB+2], j.b.boxes[nodeB+3])
+}
@@ -0,0 +1,102 @@
+package flatrtree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type refPair struct{ a, b int64 }
+
+func sortPairs(pairs []refPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].a != pairs[j].a {
+			return pairs[i].a < pairs[j].a
+		}
+		return pairs[i].b < pairs[j].b
+	})
+}
+
+func TestJoin(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for nameA, newBuilderA := range testBuilders {
+		for nameB, newBuilderB := range testBuilders {
+			// different sizes so the trees have different heights
+			boxesA := randomBoxes(rng, 500, 5)
+			boxesB := randomBoxes(rng, 40, 10)
+			a := buildIndex(t, newBuilderA, boxesA, 4)
+			b := buildIndex(t, newBuilderB, boxesB, DefaultDegree)
+
+			var expected []refPair
+			for i := 0; i < len(boxesA)/4; i++ {
+				for k := 0; k < len(boxesB)/4; k++ {
+					if boxIntersects(boxesA[i*4:], boxesB[k*4], boxesB[k*4+1], boxesB[k*4+2], boxesB[k*4+3]) {
+						expected = append(expected, refPair{int64(i), int64(k)})
+					}
+				}
+			}
+			require.NotEmpty(t, expected)
+
+			var actual []refPair
+			Join(a, b, func(refA, refB int64) bool {
+				actual = append(actual, refPair{refA, refB})
+				return true
+			})
+			sortPairs(actual)
+			require.Equal(t, expected, actual, nameA+"/"+nameB)
+		}
+	}
+}
+
+func TestSelfJoin(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for name, newBuilder := range testBuilders {
+		for _, n := range []int{0, 1, 2, 300} {
+			boxes := randomBoxes(rng, n, 8)
+			index := buildIndex(t, newBuilder, boxes, 4)
+
+			var expected []refPair
+			for i := 0; i < n; i++ {
+				for k := i + 1; k < n; k++ {
+					if boxIntersects(boxes[i*4:], boxes[k*4], boxes[k*4+1], boxes[k*4+2], boxes[k*4+3]) {
+						expected = append(expected, refPair{int64(i), int64(k)})
+					}
+				}
+			}
+
+			var actual []refPair
+			SelfJoin(index, func(refA, refB int64) bool {
+				if refA > refB {
+					refA, refB = refB, refA
+				}
+				actual = append(actual, refPair{refA, refB})
+				return true
+			})
+			sortPairs(actual)
+			require.Equal(t, expected, actual, name)
+		}
+	}
+}
+
+func TestJoinEarlyTermination(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	index := buildIndex(t, testBuilders["Hilbert"], randomBoxes(rng, 200, 10), 4)
+
+	n := 0
+	Join(index, index, func(refA, refB int64) bool {
+		n++
+		return n < 5
+	})
+	require.Equal(t, 5, n)
+
+	n = 0
+	SelfJoin(index, func(refA, refB int64) bool {
+		n++
+		return n < 5
+	})
+	require.Equal(t, 5, n)
+}
@@ -0,0 +1,81 @@
+package flatrtree
+
+import (
+	"sync"
+
+	"github.com/invisiblefunnel/flatqueue-go/v2"
+)
+
+// Neighbor is an item returned by KNN.
+type Neighbor struct {
+	Ref  int64
+	Dist float64
+}
+
+// queuePool reuses queue storage between calls to KNN. Queues are
+// drained before being returned to the pool.
+var queuePool = sync.Pool{
+	New: func() any {
+		return &flatqueue.FlatQueue[int64, float64]{}
+	},
+}
+
+// maxPooledQueueLen bounds the entries drained from a queue before it
+// is returned to queuePool. Each Pop costs O(log n), so larger queues
+// are left to the garbage collector instead.
+const maxPooledQueueLen = 1024
+
+// putQueue returns queue to queuePool, empty and ready for reuse
+func putQueue(queue *flatqueue.FlatQueue[int64, float64]) {
+	queuePool.Put(resetQueue(queue))
+}
+
+// resetQueue returns an empty queue to use in place of queue. Queues
+// holding more than maxPooledQueueLen entries are replaced rather than
+// drained.
+func resetQueue(queue *flatqueue.FlatQueue[int64, float64]) *flatqueue.FlatQueue[int64, float64] {
+	if queue.Len() > maxPooledQueueLen {
+		return &flatqueue.FlatQueue[int64, float64]{}
+	}
+	for queue.Len() > 0 {
+		queue.Pop()
+	}
+	return queue
+}
+
+// KNN returns up to k items in ascending order of distance to the given
+// coordinates, excluding items farther than maxDist. maxDist is in the
+// units boxDist and itemDist return, so it is a squared distance with
+// PlanarBoxDist. Pass math.Inf(1) as maxDist for no limit. It returns
+// nil if k <= 0.
+//
+// See Neighbors for a description of boxDist and itemDist.
+func (r *RTree) KNN(
+	x, y float64,
+	k int,
+	maxDist float64,
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) []Neighbor {
+	if boxDist == nil {
+		panic("boxDist nil")
+	}
+
+	if k <= 0 || r.count == 0 {
+		return nil
+	}
+
+	queue := queuePool.Get().(*flatqueue.FlatQueue[int64, float64])
+	defer putQueue(queue)
+
+	var result []Neighbor
+	r.neighbors(queue, x, y, func(ref int64, dist float64) bool {
+		if dist > maxDist {
+			return false
+		}
+		result = append(result, Neighbor{ref, dist})
+		return len(result) < k
+	}, boxDist, itemDist)
+
+	return result
+}
@@ -0,0 +1,141 @@
+package flatrtree
+
+import (
+	"github.com/invisiblefunnel/flatqueue-go/v2"
+)
+
+// KNNJoin calls the iterf function with the k nearest items in b for
+// each item in a, in ascending order of distance for each item in a.
+// Items of a are visited in the order they are stored in the index. If
+// iterf returns false the join will terminate.
+//
+// Distances are calculated with the rectDist function, such as
+// PlanarRectRectDist. The itemDist function can optionally be supplied
+// to calculate a more accurate distance between items. Take care that
+// itemDist and rectDist return distances in the same units.
+func KNNJoin(
+	a, b *RTree,
+	k int,
+	rectDist func(aMinX, aMinY, aMaxX, aMaxY, bMinX, bMinY, bMaxX, bMaxY float64) (dist float64),
+	itemDist func(refA, refB int64) (dist float64),
+	iterf func(refA, refB int64, dist float64) (next bool),
+) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if rectDist == nil {
+		panic("rectDist nil")
+	}
+
+	if k <= 0 || a.count == 0 || b.count == 0 {
+		return
+	}
+
+	queue := queuePool.Get().(*flatqueue.FlatQueue[int64, float64])
+	defer func() { putQueue(queue) }()
+
+	for refIdxA := 0; refIdxA < a.count; refIdxA++ {
+		refA := a.refs[refIdxA]
+		box := a.boxes[refIdxA*4 : refIdxA*4+4]
+
+		var itemDistB func(refB int64) float64
+		if itemDist != nil {
+			itemDistB = func(refB int64) float64 { return itemDist(refA, refB) }
+		}
+
+		found, next := 0, true
+		b.boxNeighbors(queue, box[0], box[1], box[2], box[3], func(refB int64, dist float64) bool {
+			found++
+			next = iterf(refA, refB, dist)
+			return next && found < k
+		}, rectDist, itemDistB)
+
+		queue = resetQueue(queue)
+
+		if !next {
+			return
+		}
+	}
+}
+
+// nodePair is a pair of nodes, one from each tree, in ClosestPairs
+type nodePair struct {
+	a, b int64
+}
+
+// ClosestPairs calls the iterf function for pairs of items, one from a
+// and one from b, in ascending order of distance. If iterf returns false
+// the search will terminate; otherwise every pair is eventually visited.
+//
+// Distances are calculated with the rectDist function, such as
+// PlanarRectRectDist. Node pairs are visited best first, so finding the
+// closest pair only visits pairs of nodes closer than it.
+//
+// Pairs waiting to be visited are held in a queue, which grows with the
+// number of pairs visited and holds up to a.Count() * b.Count() item
+// pairs if iterf never stops the search. To bound memory, stop after as
+// many pairs as needed, or use KNNJoin.
+func ClosestPairs(
+	a, b *RTree,
+	rectDist func(aMinX, aMinY, aMaxX, aMaxY, bMinX, bMinY, bMaxX, bMaxY float64) (dist float64),
+	iterf func(refA, refB int64, dist float64) (next bool),
+) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if rectDist == nil {
+		panic("rectDist nil")
+	}
+
+	if a.count == 0 || b.count == 0 {
+		return
+	}
+
+	var (
+		queue  flatqueue.FlatQueue[nodePair, float64]
+		countA int64 = int64(a.count)
+		countB int64 = int64(b.count)
+	)
+
+	pairDist := func(nodeA, nodeB int64) float64 {
+		return rectDist(
+			a.boxes[nodeA], a.boxes[nodeA+1], a.boxes[nodeA+2], a.boxes[nodeA+3],
+			b.boxes[nodeB], b.boxes[nodeB+1], b.boxes[nodeB+2], b.boxes[nodeB+3],
+		)
+	}
+
+	rootA, rootB := int64(len(a.boxes)-4), int64(len(b.boxes)-4)
+	queue.Push(nodePair{rootA, rootB}, pairDist(rootA, rootB))
+
+	for queue.Len() > 0 {
+		dist := queue.PeekValue()
+		pair := queue.Pop()
+		refIdxA, refIdxB := pair.a/4, pair.b/4
+		itemA, itemB := refIdxA < countA, refIdxB < countB
+
+		if itemA && itemB {
+			if !iterf(a.refs[refIdxA], b.refs[refIdxB], dist) {
+				return
+			}
+			continue
+		}
+
+		// expand the larger node, or the one that is not an item
+		expandA := !itemA && (itemB || boxArea(a.boxes[pair.a:]) >= boxArea(b.boxes[pair.b:]))
+		if expandA {
+			for childA := a.refs[refIdxA]; childA < a.refs[refIdxA+1]; childA += 4 {
+				queue.Push(nodePair{childA, pair.b}, pairDist(childA, pair.b))
+			}
+		} else {
+			for childB := b.refs[refIdxB]; childB < b.refs[refIdxB+1]; childB += 4 {
+				queue.Push(nodePair{pair.a, childB}, pairDist(pair.a, childB))
+			}
+		}
+	}
+}
+
+func boxArea(box []float64) float64 {
+	return (box[2] - box[0]) * (box[3] - box[1])
+}
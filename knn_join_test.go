@@ -0,0 +1,123 @@
+package flatrtree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/invisiblefunnel/flatqueue-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKNNJoin(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for name, newBuilder := range testBuilders {
+		boxesA := randomBoxes(rng, 100, 3)
+		boxesB := randomBoxes(rng, 300, 3)
+		a := buildIndex(t, newBuilder, boxesA, 4)
+		b := buildIndex(t, newBuilder, boxesB, DefaultDegree)
+
+		const k = 5
+		results := make(map[int64][]float64)
+		KNNJoin(a, b, k, PlanarRectRectDist, nil, func(refA, refB int64, dist float64) bool {
+			box := boxesB[refB*4:]
+			require.Equal(t, PlanarRectRectDist(
+				boxesA[refA*4], boxesA[refA*4+1], boxesA[refA*4+2], boxesA[refA*4+3],
+				box[0], box[1], box[2], box[3],
+			), dist)
+			results[refA] = append(results[refA], dist)
+			return true
+		})
+		require.Len(t, results, 100, name)
+
+		for refA, dists := range results {
+			var expected []float64
+			for j := 0; j < len(boxesB)/4; j++ {
+				expected = append(expected, PlanarRectRectDist(
+					boxesA[refA*4], boxesA[refA*4+1], boxesA[refA*4+2], boxesA[refA*4+3],
+					boxesB[j*4], boxesB[j*4+1], boxesB[j*4+2], boxesB[j*4+3],
+				))
+			}
+			sort.Float64s(expected)
+			require.Equal(t, expected[:k], dists, name)
+		}
+	}
+}
+
+func TestKNNJoinItemDist(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	boxes := randomBoxes(rng, 50, 3)
+	index := buildIndex(t, testBuilders["Hilbert"], boxes, 4)
+
+	// distance between box centers, never less than the box distance
+	itemDist := func(refA, refB int64) float64 {
+		ax, ay := (boxes[refA*4]+boxes[refA*4+2])/2, (boxes[refA*4+1]+boxes[refA*4+3])/2
+		bx, by := (boxes[refB*4]+boxes[refB*4+2])/2, (boxes[refB*4+1]+boxes[refB*4+3])/2
+		return (ax-bx)*(ax-bx) + (ay-by)*(ay-by)
+	}
+
+	// each item is its own nearest neighbor
+	KNNJoin(index, index, 1, PlanarRectRectDist, itemDist, func(refA, refB int64, dist float64) bool {
+		require.Equal(t, refA, refB)
+		require.Equal(t, 0.0, dist)
+		return true
+	})
+}
+
+func TestKNNJoinPanicDrainsQueue(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	boxes := randomBoxes(rng, 200, 3)
+	index := buildIndex(t, testBuilders["Hilbert"], boxes, 4)
+
+	require.Panics(t, func() {
+		KNNJoin(index, index, 10, PlanarRectRectDist, nil, func(refA, refB int64, dist float64) bool {
+			panic("iterf")
+		})
+	})
+
+	// a queue left with entries would leak them into the next query
+	queue := queuePool.Get().(*flatqueue.FlatQueue[int64, float64])
+	require.Equal(t, 0, queue.Len())
+	queuePool.Put(queue)
+}
+
+func TestClosestPairs(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for name, newBuilder := range testBuilders {
+		boxesA := randomBoxes(rng, 60, 2)
+		boxesB := randomBoxes(rng, 80, 2)
+		a := buildIndex(t, newBuilder, boxesA, 4)
+		b := buildIndex(t, newBuilder, boxesB, 5)
+
+		var expected []float64
+		for i := 0; i < len(boxesA)/4; i++ {
+			for j := 0; j < len(boxesB)/4; j++ {
+				expected = append(expected, PlanarRectRectDist(
+					boxesA[i*4], boxesA[i*4+1], boxesA[i*4+2], boxesA[i*4+3],
+					boxesB[j*4], boxesB[j*4+1], boxesB[j*4+2], boxesB[j*4+3],
+				))
+			}
+		}
+		sort.Float64s(expected)
+
+		var actual []float64
+		seen := make(map[refPair]bool)
+		ClosestPairs(a, b, PlanarRectRectDist, func(refA, refB int64, dist float64) bool {
+			require.False(t, seen[refPair{refA, refB}])
+			seen[refPair{refA, refB}] = true
+			actual = append(actual, dist)
+			return true
+		})
+		require.Equal(t, expected, actual, name)
+
+		// early termination
+		n := 0
+		ClosestPairs(a, b, PlanarRectRectDist, func(refA, refB int64, dist float64) bool {
+			n++
+			return n < 10
+		})
+		require.Equal(t, 10, n)
+	}
+}
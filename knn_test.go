@@ -0,0 +1,109 @@
+package flatrtree
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/invisiblefunnel/flatqueue-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKNN(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points := cities[:2000]
+
+	for name, boxDist := range map[string]func(pX, pY, minX, minY, maxX, maxY float64) float64{
+		"Planar":   PlanarBoxDist,
+		"Geodetic": GeodeticBoxDist,
+	} {
+		t.Run(name, func(t *testing.T) {
+			for builderName, newBuilder := range testBuilders {
+				builder := newBuilder()
+				for i, city := range points {
+					builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+				}
+				index, err := builder.Finish(DefaultDegree)
+				require.Nil(t, err)
+
+				for q := 0; q < 50; q++ {
+					x, y := rng.Float64()*360-180, rng.Float64()*180-90
+					k := 1 + rng.Intn(20)
+
+					dists := make([]float64, len(points))
+					for i, city := range points {
+						dists[i] = boxDist(x, y, city.Lon, city.Lat, city.Lon, city.Lat)
+					}
+					sorted := append([]float64(nil), dists...)
+					sort.Float64s(sorted)
+
+					// unlimited distance
+					result := index.KNN(x, y, k, math.Inf(1), boxDist, nil)
+					require.Len(t, result, k, builderName)
+					for i, n := range result {
+						require.Equal(t, sorted[i], n.Dist)
+						require.Equal(t, dists[n.Ref], n.Dist)
+					}
+
+					// limited by distance
+					maxDist := sorted[k/2]
+					result = index.KNN(x, y, k, maxDist, boxDist, nil)
+					expected := sort.Search(len(sorted), func(i int) bool { return sorted[i] > maxDist })
+					if expected > k {
+						expected = k
+					}
+					require.Len(t, result, expected, builderName)
+					for _, n := range result {
+						require.LessOrEqual(t, n.Dist, maxDist)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestKNNEmpty(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+	require.Nil(t, index.KNN(0, 0, 0, math.Inf(1), PlanarBoxDist, nil))
+	require.Nil(t, index.KNN(0, 0, 10, -1, PlanarBoxDist, nil))
+	require.Nil(t, (&RTree{}).KNN(0, 0, 10, math.Inf(1), PlanarBoxDist, nil))
+	require.Len(t, index.KNN(0, 0, 1000, math.Inf(1), PlanarBoxDist, nil), 100)
+}
+
+func TestKNNItemDist(t *testing.T) {
+	index, items := createIndex(t, testBuilders["OMT"], 100, DefaultDegree)
+
+	// distance to the far corner of each item
+	itemDist := func(x, y float64, ref int64) float64 {
+		dx, dy := items[ref*4+2]-x, items[ref*4+3]-y
+		return dx*dx + dy*dy
+	}
+
+	var expected []Neighbor
+	index.Neighbors(50, 50, func(ref int64, dist float64) bool {
+		expected = append(expected, Neighbor{ref, dist})
+		return len(expected) < 5
+	}, PlanarBoxDist, itemDist)
+
+	require.Equal(t, expected, index.KNN(50, 50, 5, math.Inf(1), PlanarBoxDist, itemDist))
+}
+
+func TestResetQueue(t *testing.T) {
+	small := &flatqueue.FlatQueue[int64, float64]{}
+	for i := 0; i < 10; i++ {
+		small.Push(int64(i), float64(i))
+	}
+	require.Same(t, small, resetQueue(small))
+	require.Equal(t, 0, small.Len())
+
+	// large queues are replaced rather than drained
+	large := &flatqueue.FlatQueue[int64, float64]{}
+	for i := 0; i <= maxPooledQueueLen; i++ {
+		large.Push(int64(i), float64(i))
+	}
+	reset := resetQueue(large)
+	require.NotSame(t, large, reset)
+	require.Equal(t, 0, reset.Len())
+	require.Equal(t, maxPooledQueueLen+1, large.Len())
+}
@@ -0,0 +1,17 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package flatrtree
+
+import (
+	"os"
+)
+
+// OpenMmap reads an index in the fixed-width format. Memory mapping is
+// not supported on this platform, so the file is read into memory.
+func OpenMmap(path string) (*FixedRTree, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenFixed(data)
+}
@@ -0,0 +1,50 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package flatrtree
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// OpenMmap memory maps an index in the fixed-width format. Only the
+// header is read when opening, and pages are loaded by the operating
+// system as queries touch them. Call Close to release the mapping.
+func OpenMmap(path string) (*FixedRTree, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if size < fixedHeaderSize {
+		return nil, errFixedFormat
+	}
+	if int64(int(size)) != size {
+		return nil, errors.New("flatrtree: file too large to map")
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := OpenFixed(data)
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+
+	t.close = func() error {
+		return syscall.Munmap(data)
+	}
+
+	return t, nil
+}
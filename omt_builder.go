@@ -1,26 +1,39 @@
 package flatrtree
 
 import (
-	"errors"
-	"fmt"
 	"math"
+	"sync"
 
 	"github.com/furstenheim/nth_element/FloydRivest"
 )
 
 var _ Builder = &OMTBuilder{}
 
+// OMTOptions configures an OMTBuilder.
+type OMTOptions struct {
+	// Parallelism is the number of goroutines used to partition items.
+	// Values below 2 build on the calling goroutine. Partitions of
+	// separate slices are independent, so the index is the same for any
+	// Parallelism.
+	Parallelism int
+}
+
 type OMTBuilder struct {
 	count int
 	refs  []int64
 	boxes []float64
+	opts  OMTOptions
 
-	nodeSizes [][]int
-	nodeBoxes [][]float64
+	workers workers
+	omtLevels
 }
 
 func NewOMTBuilder() *OMTBuilder {
-	return &OMTBuilder{}
+	return NewOMTBuilderWithOptions(OMTOptions{})
+}
+
+func NewOMTBuilderWithOptions(opts OMTOptions) *OMTBuilder {
+	return &OMTBuilder{opts: opts}
 }
 
 func (b *OMTBuilder) Add(ref int64, minX, minY, maxX, maxY float64) {
@@ -29,28 +42,88 @@ func (b *OMTBuilder) Add(ref int64, minX, minY, maxX, maxY float64) {
 	b.boxes = append(b.boxes, minX, minY, maxX, maxY)
 }
 
+// AddBoxes adds items with refs[i] and the box at boxes[i*4:i*4+4].
+// It panics if len(boxes) != 4*len(refs).
+func (b *OMTBuilder) AddBoxes(refs []int64, boxes []float64) {
+	if len(boxes) != 4*len(refs) {
+		panic("len(boxes) != 4*len(refs)")
+	}
+
+	b.count += len(refs)
+	b.refs = append(b.refs, refs...)
+	b.boxes = append(b.boxes, boxes...)
+}
+
+// Grow grows the builder's capacity to add n more items without allocating.
+func (b *OMTBuilder) Grow(n int) {
+	b.refs = growSlice(b.refs, n)
+	b.boxes = growSlice(b.boxes, 4*n)
+}
+
+// Reset removes all items so the builder can be reused, keeping its
+// buffers. An index returned by a previous Finish shares these buffers
+// and must not be used after Reset.
+func (b *OMTBuilder) Reset() {
+	b.count = 0
+	b.refs = b.refs[:0]
+	b.boxes = b.boxes[:0]
+}
+
 func (b *OMTBuilder) Finish(degree int) (*RTree, error) {
 	if degree < 2 {
-		return nil, fmt.Errorf("degree < 2")
+		return nil, ErrInvalidDegree
+	}
+
+	return b.FinishWithOptions(BuildOptions{LeafDegree: degree, NodeDegree: degree})
+}
+
+// FinishWithOptions is Finish with separate degrees for leaves and the
+// nodes above them.
+func (b *OMTBuilder) FinishWithOptions(opts BuildOptions) (*RTree, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
 	}
 
 	if b.count == 0 {
 		return &RTree{}, nil
 	}
 
+	if err := checkCount(b.count); err != nil {
+		return nil, err
+	}
+
 	if len(b.refs) != b.count {
-		return nil, errors.New("Finish called more than once")
+		return nil, ErrFinishCalledTwice
 	}
 
-	targetHeight := int(math.Ceil(math.Log(float64(b.count)) / math.Log(float64(degree))))
-	if targetHeight < 1 {
-		targetHeight = 1
+	if opts.Strict {
+		if err := checkBoxes(b.refs, b.boxes); err != nil {
+			return nil, err
+		}
 	}
 
-	b.nodeSizes = make([][]int, targetHeight)
-	b.nodeBoxes = make([][]float64, targetHeight)
+	// capacities[level] is the most items under a node on level
+	capacities := []int{opts.LeafDegree}
+	for capacities[len(capacities)-1] < b.count {
+		capacities = append(capacities, capacities[len(capacities)-1]*opts.NodeDegree)
+	}
 
-	b.build(degree, 0, b.count, targetHeight-1)
+	// With a single degree, keep the height of earlier releases so
+	// their trees keep the same shape. Rounding makes it one level
+	// higher for some counts that are powers of the degree.
+	if opts.LeafDegree == opts.NodeDegree {
+		height := int(math.Ceil(math.Log(float64(b.count)) / math.Log(float64(opts.LeafDegree))))
+		for len(capacities) < height {
+			capacities = append(capacities, capacities[len(capacities)-1]*opts.NodeDegree)
+		}
+	}
+	targetHeight := len(capacities)
+
+	b.omtLevels.reset(targetHeight)
+	b.workers = newWorkers(b.opts.Parallelism)
+
+	b.build(capacities, 0, b.count, targetHeight-1, &b.omtLevels)
 	b.pack()
 
 	return &RTree{
@@ -60,9 +133,14 @@ func (b *OMTBuilder) Finish(degree int) (*RTree, error) {
 	}, nil
 }
 
-func (b *OMTBuilder) build(degree, start, end, level int) (float64, float64, float64, float64) {
+func (b *OMTBuilder) build(capacities []int, start, end, level int, nodes *omtLevels) (float64, float64, float64, float64) {
 	N := end - start
-	childCount := int(math.Ceil(float64(N) / math.Pow(float64(degree), float64(level))))
+
+	childCapacity := 1
+	if level > 0 {
+		childCapacity = capacities[level-1]
+	}
+	childCount := (N + childCapacity - 1) / childCapacity
 
 	minX := math.Inf(1)
 	minY := math.Inf(1)
@@ -77,44 +155,91 @@ func (b *OMTBuilder) build(degree, start, end, level int) (float64, float64, flo
 			maxY = math.Max(maxY, b.boxes[i*4+3])
 		}
 
-		b.addNode(level, N, minX, minY, maxX, maxY)
+		nodes.addNode(level, N, minX, minY, maxX, maxY)
 		return minX, minY, maxX, maxY
 	}
 
-	nodeSize := 0
 	nodeCapacity := int(math.Ceil(float64(N) / float64(childCount)))
 	sliceCapacity := nodeCapacity * int(math.Ceil(math.Sqrt(float64(childCount))))
 
 	sortByX(b.refs[start:end], b.boxes[start*4:end*4], sliceCapacity)
 
+	// Slices are built concurrently into separate levels, which are
+	// appended in order once all slices are done.
+	parallel := b.workers != nil && N >= minParallelItems
+
+	var (
+		wg     sync.WaitGroup
+		slices []*omtSlice
+	)
 	for sliceStart := start; sliceStart < end; sliceStart += sliceCapacity {
 		sliceEnd := sliceStart + sliceCapacity
 		if sliceEnd > end {
 			sliceEnd = end
 		}
 
-		sortByY(b.refs[sliceStart:sliceEnd], b.boxes[sliceStart*4:sliceEnd*4], nodeCapacity)
+		slice := &omtSlice{start: sliceStart, end: sliceEnd, nodes: nodes}
+		slices = append(slices, slice)
 
-		for childStart := sliceStart; childStart < sliceEnd; childStart += nodeCapacity {
-			childEnd := childStart + nodeCapacity
-			if childEnd > end {
-				childEnd = end
-			}
-
-			childMinX, childMinY, childMaxX, childMaxY := b.build(degree, childStart, childEnd, level-1)
+		if parallel {
+			levels := newOMTLevels(level)
+			slice.nodes = &levels
+			b.workers.run(&wg, func() { b.buildSlice(capacities, slice, nodeCapacity, end, level) })
+		} else {
+			b.buildSlice(capacities, slice, nodeCapacity, end, level)
+		}
+	}
+	wg.Wait()
 
-			minX = math.Min(minX, childMinX)
-			minY = math.Min(minY, childMinY)
-			maxX = math.Max(maxX, childMaxX)
-			maxY = math.Max(maxY, childMaxY)
-			nodeSize++
+	nodeSize := 0
+	for _, slice := range slices {
+		if parallel {
+			nodes.extend(slice.nodes)
 		}
+
+		minX = math.Min(minX, slice.minX)
+		minY = math.Min(minY, slice.minY)
+		maxX = math.Max(maxX, slice.maxX)
+		maxY = math.Max(maxY, slice.maxY)
+		nodeSize += slice.size
 	}
 
-	b.addNode(level, nodeSize, minX, minY, maxX, maxY)
+	nodes.addNode(level, nodeSize, minX, minY, maxX, maxY)
 	return minX, minY, maxX, maxY
 }
 
+// omtSlice is a vertical slice of a node's items, split into children
+type omtSlice struct {
+	start, end             int
+	size                   int
+	minX, minY, maxX, maxY float64
+	nodes                  *omtLevels
+}
+
+func (b *OMTBuilder) buildSlice(capacities []int, slice *omtSlice, nodeCapacity, end, level int) {
+	slice.minX = math.Inf(1)
+	slice.minY = math.Inf(1)
+	slice.maxX = math.Inf(-1)
+	slice.maxY = math.Inf(-1)
+
+	sortByY(b.refs[slice.start:slice.end], b.boxes[slice.start*4:slice.end*4], nodeCapacity)
+
+	for childStart := slice.start; childStart < slice.end; childStart += nodeCapacity {
+		childEnd := childStart + nodeCapacity
+		if childEnd > end {
+			childEnd = end
+		}
+
+		childMinX, childMinY, childMaxX, childMaxY := b.build(capacities, childStart, childEnd, level-1, slice.nodes)
+
+		slice.minX = math.Min(slice.minX, childMinX)
+		slice.minY = math.Min(slice.minY, childMinY)
+		slice.maxX = math.Max(slice.maxX, childMaxX)
+		slice.maxY = math.Max(slice.maxY, childMaxY)
+		slice.size++
+	}
+}
+
 func (b *OMTBuilder) pack() {
 	var ref int64
 	b.refs = append(b.refs, ref)
@@ -133,9 +258,44 @@ func (b *OMTBuilder) pack() {
 	}
 }
 
-func (b *OMTBuilder) addNode(level, size int, minX, minY, maxX, maxY float64) {
-	b.nodeSizes[level] = append(b.nodeSizes[level], size)
-	b.nodeBoxes[level] = append(b.nodeBoxes[level], minX, minY, maxX, maxY)
+// omtLevels holds the sizes and boxes of nodes on each level, in order
+type omtLevels struct {
+	nodeSizes [][]int
+	nodeBoxes [][]float64
+}
+
+func newOMTLevels(height int) omtLevels {
+	return omtLevels{
+		nodeSizes: make([][]int, height),
+		nodeBoxes: make([][]float64, height),
+	}
+}
+
+// reset empties height levels, reusing their buffers
+func (l *omtLevels) reset(height int) {
+	for len(l.nodeSizes) < height {
+		l.nodeSizes = append(l.nodeSizes, nil)
+		l.nodeBoxes = append(l.nodeBoxes, nil)
+	}
+	l.nodeSizes = l.nodeSizes[:height]
+	l.nodeBoxes = l.nodeBoxes[:height]
+	for level := range l.nodeSizes {
+		l.nodeSizes[level] = l.nodeSizes[level][:0]
+		l.nodeBoxes[level] = l.nodeBoxes[level][:0]
+	}
+}
+
+func (l *omtLevels) addNode(level, size int, minX, minY, maxX, maxY float64) {
+	l.nodeSizes[level] = append(l.nodeSizes[level], size)
+	l.nodeBoxes[level] = append(l.nodeBoxes[level], minX, minY, maxX, maxY)
+}
+
+// extend appends the nodes of other after the nodes on each level
+func (l *omtLevels) extend(other *omtLevels) {
+	for level := range other.nodeSizes {
+		l.nodeSizes[level] = append(l.nodeSizes[level], other.nodeSizes[level]...)
+		l.nodeBoxes[level] = append(l.nodeBoxes[level], other.nodeBoxes[level]...)
+	}
 }
 
 //
@@ -0,0 +1,75 @@
+package flatrtree
+
+import "sync"
+
+// minParallelItems is the fewest items worth handing to another goroutine
+const minParallelItems = 1 << 14
+
+// splitRanges returns the bounds of up to parallelism contiguous
+// ranges covering [0, n), so range i is [bounds[i], bounds[i+1]),
+// or nil if n is too small to split.
+func splitRanges(n, parallelism int) []int {
+	if parallelism > n/minParallelItems {
+		parallelism = n / minParallelItems
+	}
+
+	if parallelism < 2 {
+		return nil
+	}
+
+	bounds := make([]int, 0, parallelism+1)
+	size := (n + parallelism - 1) / parallelism
+	for start := 0; start < n; start += size {
+		bounds = append(bounds, start)
+	}
+	return append(bounds, n)
+}
+
+// parallelRanges calls f concurrently on the ranges from splitRanges,
+// returning when all calls are done.
+func parallelRanges(n, parallelism int, f func(start, end int)) {
+	bounds := splitRanges(n, parallelism)
+	if bounds == nil {
+		f(0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i+1 < len(bounds); i++ {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			f(start, end)
+		}(bounds[i], bounds[i+1])
+	}
+	wg.Wait()
+}
+
+// workers limits the goroutines started by a parallel build. A nil
+// workers runs everything on the calling goroutine.
+type workers chan struct{}
+
+func newWorkers(parallelism int) workers {
+	if parallelism < 2 {
+		return nil
+	}
+	return make(workers, parallelism-1)
+}
+
+// run calls f on a new goroutine if a worker is free,
+// otherwise on the calling goroutine.
+func (w workers) run(wg *sync.WaitGroup, f func()) {
+	select {
+	case w <- struct{}{}:
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-w
+				wg.Done()
+			}()
+			f()
+		}()
+	default:
+		f()
+	}
+}
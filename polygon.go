@@ -0,0 +1,306 @@
+package flatrtree
+
+import "math"
+
+// SearchPolygon calls the iterf function for all items whose box
+// intersects the polygon. If iterf returns false the search will
+// terminate.
+//
+// Each ring is a flat list of x, y coordinates, closed or not. The first
+// ring is the exterior and any others are holes, with points inside an
+// odd number of rings considered inside the polygon. Nodes are tested
+// against the polygon edges rather than its bounding box, and nodes
+// entirely inside the polygon are reported without further tests.
+func (r *RTree) SearchPolygon(rings [][]float64, iterf func(ref int64) (next bool)) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if r.count == 0 {
+		return
+	}
+
+	p := newPolygon(rings)
+	if p.empty() {
+		return
+	}
+
+	rootNodeIdx := int64(len(r.boxes) - 4)
+	switch p.relate(r.boxes[rootNodeIdx:]) {
+	case boxInside:
+		r.all(rootNodeIdx, iterf)
+	case boxCrosses:
+		r.searchPolygon(rootNodeIdx, &p, iterf)
+	}
+}
+
+func (r *RTree) searchPolygon(nodeIdx int64, p *polygon, iterf func(ref int64) (next bool)) bool {
+	var (
+		refIdx       int64 = nodeIdx / 4
+		childNodeIdx int64
+		childRefIdx  int64
+		count        int64 = int64(r.count)
+	)
+
+	for childNodeIdx = r.refs[refIdx]; childNodeIdx < r.refs[refIdx+1]; childNodeIdx += 4 {
+		relation := p.relate(r.boxes[childNodeIdx:])
+		if relation == boxOutside {
+			continue
+		}
+
+		childRefIdx = childNodeIdx / 4
+		if childRefIdx < count {
+			if !iterf(r.refs[childRefIdx]) {
+				return false
+			}
+		} else if relation == boxInside {
+			if !r.all(childNodeIdx, iterf) {
+				return false
+			}
+		} else {
+			if !r.searchPolygon(childNodeIdx, p, iterf) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// SearchLineString calls the iterf function for all items whose box is
+// within buffer of the line. If iterf returns false the search will
+// terminate.
+//
+// The line is a flat list of x, y coordinates and buffer is a planar
+// distance in the same units. Nodes are tested against the line
+// segments rather than the bounding box of the line.
+func (r *RTree) SearchLineString(coords []float64, buffer float64, iterf func(ref int64) (next bool)) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if r.count == 0 || len(coords) < 2 {
+		return
+	}
+
+	l := lineString{coords: coords, buffer: buffer}
+	l.minX, l.minY, l.maxX, l.maxY = coordsBounds(coords)
+
+	rootNodeIdx := int64(len(r.boxes) - 4)
+	if l.intersects(r.boxes[rootNodeIdx:]) {
+		r.searchLineString(rootNodeIdx, &l, iterf)
+	}
+}
+
+func (r *RTree) searchLineString(nodeIdx int64, l *lineString, iterf func(ref int64) (next bool)) bool {
+	var (
+		refIdx       int64 = nodeIdx / 4
+		childNodeIdx int64
+		childRefIdx  int64
+		count        int64 = int64(r.count)
+	)
+
+	for childNodeIdx = r.refs[refIdx]; childNodeIdx < r.refs[refIdx+1]; childNodeIdx += 4 {
+		if !l.intersects(r.boxes[childNodeIdx:]) {
+			continue
+		}
+
+		childRefIdx = childNodeIdx / 4
+		if childRefIdx < count {
+			if !iterf(r.refs[childRefIdx]) {
+				return false
+			}
+		} else {
+			if !r.searchLineString(childNodeIdx, l, iterf) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// all calls iterf for every item below the node
+func (r *RTree) all(nodeIdx int64, iterf func(ref int64) (next bool)) bool {
+	refIdx := nodeIdx / 4
+	if refIdx < int64(r.count) {
+		return iterf(r.refs[refIdx])
+	}
+
+	for childNodeIdx := r.refs[refIdx]; childNodeIdx < r.refs[refIdx+1]; childNodeIdx += 4 {
+		if !r.all(childNodeIdx, iterf) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type boxRelation uint8
+
+const (
+	boxOutside boxRelation = iota
+	boxCrosses
+	boxInside
+)
+
+type polygon struct {
+	rings                  [][]float64
+	minX, minY, maxX, maxY float64
+}
+
+func newPolygon(rings [][]float64) polygon {
+	p := polygon{
+		rings: rings,
+		minX:  math.Inf(1),
+		minY:  math.Inf(1),
+		maxX:  math.Inf(-1),
+		maxY:  math.Inf(-1),
+	}
+	if len(rings) > 0 {
+		p.minX, p.minY, p.maxX, p.maxY = coordsBounds(rings[0])
+	}
+	return p
+}
+
+func (p *polygon) empty() bool {
+	return p.minX > p.maxX
+}
+
+// relate classifies a box as outside the polygon, crossing its
+// boundary, or entirely inside it
+func (p *polygon) relate(box []float64) boxRelation {
+	if !boxIntersects(box, p.minX, p.minY, p.maxX, p.maxY) {
+		return boxOutside
+	}
+
+	for _, ring := range p.rings {
+		n := len(ring) / 2
+		for i := 0; i < n; i++ {
+			j := (i + 1) % n
+			if segmentIntersectsBox(ring[i*2], ring[i*2+1], ring[j*2], ring[j*2+1], box) {
+				return boxCrosses
+			}
+		}
+	}
+
+	// no edge touches the box, so it is either inside or outside
+	if p.contains(box[0], box[1]) {
+		return boxInside
+	}
+	return boxOutside
+}
+
+// contains tests a point against the rings with the even-odd rule
+func (p *polygon) contains(x, y float64) bool {
+	inside := false
+	for _, ring := range p.rings {
+		n := len(ring) / 2
+		for i, j := 0, n-1; i < n; j, i = i, i+1 {
+			xi, yi := ring[i*2], ring[i*2+1]
+			xj, yj := ring[j*2], ring[j*2+1]
+			if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+type lineString struct {
+	coords                 []float64
+	buffer                 float64
+	minX, minY, maxX, maxY float64
+}
+
+// intersects reports whether the box is within buffer of the line
+func (l *lineString) intersects(box []float64) bool {
+	if !boxIntersects(box, l.minX-l.buffer, l.minY-l.buffer, l.maxX+l.buffer, l.maxY+l.buffer) {
+		return false
+	}
+
+	n := len(l.coords) / 2
+	if n == 1 {
+		return math.Sqrt(PlanarBoxDist(l.coords[0], l.coords[1], box[0], box[1], box[2], box[3])) <= l.buffer
+	}
+
+	for i := 0; i+1 < n; i++ {
+		ax, ay := l.coords[i*2], l.coords[i*2+1]
+		bx, by := l.coords[i*2+2], l.coords[i*2+3]
+		if segmentBoxDist(ax, ay, bx, by, box) <= l.buffer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// segmentIntersectsBox reports whether the segment from a to b
+// touches the box
+func segmentIntersectsBox(ax, ay, bx, by float64, box []float64) bool {
+	minX, minY, maxX, maxY := box[0], box[1], box[2], box[3]
+
+	if math.Max(ax, bx) < minX || math.Min(ax, bx) > maxX ||
+		math.Max(ay, by) < minY || math.Min(ay, by) > maxY {
+		return false
+	}
+
+	// the bounds overlap, so the segment misses the box only
+	// if all four corners are strictly on one side of it
+	side := func(x, y float64) float64 {
+		return (bx-ax)*(y-ay) - (by-ay)*(x-ax)
+	}
+	s0 := side(minX, minY)
+	s1 := side(maxX, minY)
+	s2 := side(maxX, maxY)
+	s3 := side(minX, maxY)
+
+	return !(s0 > 0 && s1 > 0 && s2 > 0 && s3 > 0) &&
+		!(s0 < 0 && s1 < 0 && s2 < 0 && s3 < 0)
+}
+
+// segmentBoxDist returns the planar distance between the
+// segment from a to b and the box
+func segmentBoxDist(ax, ay, bx, by float64, box []float64) float64 {
+	if segmentIntersectsBox(ax, ay, bx, by, box) {
+		return 0
+	}
+
+	// without an intersection the closest points are an
+	// endpoint of the segment or a corner of the box
+	dist := math.Min(
+		PlanarBoxDist(ax, ay, box[0], box[1], box[2], box[3]),
+		PlanarBoxDist(bx, by, box[0], box[1], box[2], box[3]),
+	)
+	for _, corner := range [4][2]float64{
+		{box[0], box[1]}, {box[2], box[1]}, {box[2], box[3]}, {box[0], box[3]},
+	} {
+		dist = math.Min(dist, pointSegmentDistSq(corner[0], corner[1], ax, ay, bx, by))
+	}
+
+	return math.Sqrt(dist)
+}
+
+// pointSegmentDistSq returns the squared planar distance
+// between the point and the segment from a to b
+func pointSegmentDistSq(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/lengthSq))
+	}
+	x, y := ax+t*dx-px, ay+t*dy-py
+	return x*x + y*y
+}
+
+func coordsBounds(coords []float64) (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for i := 0; i+1 < len(coords); i += 2 {
+		minX = math.Min(minX, coords[i])
+		minY = math.Min(minY, coords[i+1])
+		maxX = math.Max(maxX, coords[i])
+		maxY = math.Max(maxY, coords[i+1])
+	}
+	return minX, minY, maxX, maxY
+}
@@ -0,0 +1,129 @@
+package flatrtree
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolygonRelate(t *testing.T) {
+	// a square with a square hole
+	p := newPolygon([][]float64{
+		{0, 0, 10, 0, 10, 10, 0, 10, 0, 0},
+		{4, 4, 6, 4, 6, 6, 4, 6},
+	})
+
+	require.Equal(t, boxInside, p.relate([]float64{1, 1, 2, 2}))
+	require.Equal(t, boxOutside, p.relate([]float64{4.5, 4.5, 5.5, 5.5}))
+	require.Equal(t, boxCrosses, p.relate([]float64{3, 3, 5, 5}))
+	require.Equal(t, boxCrosses, p.relate([]float64{-1, -1, 11, 11}))
+	require.Equal(t, boxCrosses, p.relate([]float64{9, 9, 12, 12}))
+	require.Equal(t, boxOutside, p.relate([]float64{11, 11, 12, 12}))
+
+	// a triangle whose bounding box covers the box
+	tri := newPolygon([][]float64{{0, 0, 10, 0, 0, 10}})
+	require.Equal(t, boxOutside, tri.relate([]float64{8, 8, 9, 9}))
+	require.Equal(t, boxCrosses, tri.relate([]float64{4, 4, 6, 6}))
+}
+
+func TestSegmentBoxDist(t *testing.T) {
+	box := []float64{0, 0, 1, 1}
+	require.Equal(t, 0.0, segmentBoxDist(-1, 0.5, 2, 0.5, box))
+	require.Equal(t, 0.0, segmentBoxDist(0.5, 0.5, 0.5, 0.5, box))
+	require.Equal(t, 1.0, segmentBoxDist(-1, 2, 2, 2, box))
+	require.InDelta(t, math.Sqrt2, segmentBoxDist(1, 3, 3, 1, box), 1e-12)
+	require.Equal(t, 2.0, segmentBoxDist(3, 0, 3, 0, box))
+}
+
+func TestSearchPolygon(t *testing.T) {
+	// a diagonal corridor with a hole, and a triangle
+	polygons := [][][]float64{
+		{
+			{0, 10, 10, 0, 100, 90, 90, 100},
+			{45, 50, 50, 45, 55, 50, 50, 55},
+		},
+		{{20, 20, 80, 20, 50, 90, 20, 20}},
+	}
+
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, rings := range polygons {
+				p := newPolygon(rings)
+
+				var expected []int64
+				for i := 0; i < tc.count; i++ {
+					if p.relate(tc.items[i*4:]) != boxOutside {
+						expected = append(expected, int64(i))
+					}
+				}
+
+				var actual []int64
+				tc.index.SearchPolygon(rings, func(ref int64) bool {
+					actual = append(actual, ref)
+					return true
+				})
+				sort.Slice(actual, func(i, j int) bool { return actual[i] < actual[j] })
+				require.Equal(t, expected, actual)
+
+				// never more than the bounding box
+				var bbox int
+				tc.index.Search(p.minX, p.minY, p.maxX, p.maxY, func(ref int64) bool {
+					bbox++
+					return true
+				})
+				require.LessOrEqual(t, len(actual), bbox)
+			}
+		})
+	}
+}
+
+func TestSearchLineString(t *testing.T) {
+	line := []float64{0, 0, 50, 50, 100, 0}
+
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, buffer := range []float64{0, 2, 10} {
+				var expected []int64
+				for i := 0; i < tc.count; i++ {
+					box := tc.items[i*4 : i*4+4]
+					dist := math.Min(
+						segmentBoxDist(0, 0, 50, 50, box),
+						segmentBoxDist(50, 50, 100, 0, box),
+					)
+					if dist <= buffer {
+						expected = append(expected, int64(i))
+					}
+				}
+
+				var actual []int64
+				tc.index.SearchLineString(line, buffer, func(ref int64) bool {
+					actual = append(actual, ref)
+					return true
+				})
+				sort.Slice(actual, func(i, j int) bool { return actual[i] < actual[j] })
+				require.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestSearchPolygonEarlyTermination(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	// the polygon contains the whole index
+	n := 0
+	index.SearchPolygon([][]float64{{-1, -1, 101, -1, 101, 101, -1, 101}}, func(ref int64) bool {
+		n++
+		return n < 3
+	})
+	require.Equal(t, 3, n)
+
+	n = 0
+	index.SearchLineString([]float64{0, 0, 100, 100}, 100, func(ref int64) bool {
+		n++
+		return n < 3
+	})
+	require.Equal(t, 3, n)
+}
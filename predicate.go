@@ -0,0 +1,129 @@
+package flatrtree
+
+import "fmt"
+
+// Predicate is the spatial relationship between an item's box and the
+// query box used by SearchPredicate. Boxes include their boundaries.
+type Predicate uint8
+
+const (
+	// Intersects matches items whose box shares any point
+	// with the query box, the same as Search
+	Intersects Predicate = iota
+	// Contains matches items whose box contains the query box, such
+	// as the areas containing a parcel. Query a point by passing it
+	// as both corners.
+	Contains
+	// Within matches items whose box is within the query box
+	Within
+	// StrictIntersects matches items whose box overlaps the query box
+	// by more than a shared boundary
+	StrictIntersects
+)
+
+func (p Predicate) String() string {
+	switch p {
+	case Intersects:
+		return "Intersects"
+	case Contains:
+		return "Contains"
+	case Within:
+		return "Within"
+	case StrictIntersects:
+		return "StrictIntersects"
+	default:
+		return fmt.Sprintf("Predicate(%d)", uint8(p))
+	}
+}
+
+// SearchPredicate calls the iterf function for all items whose box has
+// the given relationship with the search box. If iterf returns false
+// the search will terminate.
+func (r *RTree) SearchPredicate(
+	minX, minY, maxX, maxY float64,
+	pred Predicate,
+	iterf func(ref int64) (next bool),
+) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if pred > StrictIntersects {
+		panic(fmt.Sprintf("unknown predicate %v", pred))
+	}
+
+	if r.count == 0 {
+		return
+	}
+
+	q := [4]float64{minX, minY, maxX, maxY}
+	rootNodeIdx := int64(len(r.boxes) - 4)
+	if pred.visit(r.boxes[rootNodeIdx:], &q) {
+		r.searchPredicate(rootNodeIdx, &q, pred, iterf)
+	}
+}
+
+func (r *RTree) searchPredicate(
+	nodeIdx int64,
+	q *[4]float64,
+	pred Predicate,
+	iterf func(ref int64) (next bool),
+) bool {
+	var (
+		refIdx       int64 = nodeIdx / 4
+		childNodeIdx int64
+		childRefIdx  int64
+		count        int64 = int64(r.count)
+	)
+
+	for childNodeIdx = r.refs[refIdx]; childNodeIdx < r.refs[refIdx+1]; childNodeIdx += 4 {
+		box := r.boxes[childNodeIdx:]
+		childRefIdx = childNodeIdx / 4
+		if childRefIdx < count {
+			if pred.matches(box, q) && !iterf(r.refs[childRefIdx]) {
+				return false
+			}
+		} else if pred == Within && boxWithin(box, q) {
+			// every item below is within the query
+			if !r.all(childNodeIdx, iterf) {
+				return false
+			}
+		} else if pred.visit(box, q) {
+			if !r.searchPredicate(childNodeIdx, q, pred, iterf) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// matches tests an item's box
+func (p Predicate) matches(box []float64, q *[4]float64) bool {
+	switch p {
+	case Contains:
+		return box[0] <= q[0] && box[1] <= q[1] && box[2] >= q[2] && box[3] >= q[3]
+	case Within:
+		return boxWithin(box, q)
+	case StrictIntersects:
+		return box[0] < q[2] && box[1] < q[3] && box[2] > q[0] && box[3] > q[1]
+	default:
+		return boxIntersects(box, q[0], q[1], q[2], q[3])
+	}
+}
+
+// visit tests whether a node's box may hold matching items. A node
+// contains all of its items, so it must contain the query to hold items
+// that do, and must overlap it to hold items that overlap it.
+func (p Predicate) visit(box []float64, q *[4]float64) bool {
+	switch p {
+	case Contains, StrictIntersects:
+		return p.matches(box, q)
+	default:
+		return boxIntersects(box, q[0], q[1], q[2], q[3])
+	}
+}
+
+func boxWithin(box []float64, q *[4]float64) bool {
+	return box[0] >= q[0] && box[1] >= q[1] && box[2] <= q[2] && box[3] <= q[3]
+}
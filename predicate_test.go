@@ -0,0 +1,91 @@
+package flatrtree
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredicateMatches(t *testing.T) {
+	box := []float64{0, 0, 10, 10}
+
+	for _, tc := range []struct {
+		query    [4]float64
+		expected []Predicate
+	}{
+		{[4]float64{2, 2, 3, 3}, []Predicate{Intersects, Contains, StrictIntersects}},
+		{[4]float64{-1, -1, 11, 11}, []Predicate{Intersects, Within, StrictIntersects}},
+		{[4]float64{0, 0, 10, 10}, []Predicate{Intersects, Contains, Within, StrictIntersects}},
+		{[4]float64{10, 0, 20, 10}, []Predicate{Intersects}},
+		{[4]float64{5, 5, 15, 15}, []Predicate{Intersects, StrictIntersects}},
+		{[4]float64{10, 10, 10, 10}, []Predicate{Intersects, Contains}},
+		{[4]float64{11, 11, 12, 12}, nil},
+	} {
+		var actual []Predicate
+		for _, pred := range []Predicate{Intersects, Contains, Within, StrictIntersects} {
+			if pred.matches(box, &tc.query) {
+				actual = append(actual, pred)
+			}
+		}
+		require.Equal(t, tc.expected, actual, tc.query)
+	}
+}
+
+func TestSearchPredicate(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			var queries [][4]float64
+			for i := 0; i < tc.count; i++ {
+				item := tc.items[i*4 : i*4+4]
+				queries = append(queries,
+					[4]float64{item[0], item[1], item[2], item[3]},
+					[4]float64{item[0] - 10, item[1] - 10, item[2] + 10, item[3] + 10},
+					[4]float64{item[0], item[1], item[0], item[1]},
+					[4]float64{item[2], item[3], item[2] + 5, item[3] + 5},
+				)
+			}
+
+			for _, pred := range []Predicate{Intersects, Contains, Within, StrictIntersects} {
+				for _, q := range queries {
+					var expected []int64
+					for i := 0; i < tc.count; i++ {
+						if pred.matches(tc.items[i*4:], &q) {
+							expected = append(expected, int64(i))
+						}
+					}
+
+					var actual []int64
+					tc.index.SearchPredicate(q[0], q[1], q[2], q[3], pred, func(ref int64) bool {
+						actual = append(actual, ref)
+						return true
+					})
+					sort.Slice(actual, func(i, j int) bool { return actual[i] < actual[j] })
+					require.Equal(t, expected, actual, pred.String())
+				}
+			}
+		})
+	}
+}
+
+func TestSearchPredicateIntersectsMatchesSearch(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["OMT"], 100, DefaultDegree)
+
+	var expected, actual []int64
+	index.Search(20, 20, 60, 60, func(ref int64) bool {
+		expected = append(expected, ref)
+		return true
+	})
+	index.SearchPredicate(20, 20, 60, 60, Intersects, func(ref int64) bool {
+		actual = append(actual, ref)
+		return true
+	})
+	require.Equal(t, expected, actual)
+}
+
+func TestSearchPredicateUnknown(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["OMT"], 10, DefaultDegree)
+	require.Panics(t, func() {
+		index.SearchPredicate(0, 0, 1, 1, Predicate(9), func(int64) bool { return true })
+	})
+}
@@ -0,0 +1,83 @@
+package flatrtree
+
+// SearchRadius calls the iterf function for all items within distance r
+// of the given coordinates, in no particular order. If iterf returns
+// false the search will terminate. Unlike Neighbors it traverses the
+// tree depth first without a priority queue, pruning nodes farther
+// than r.
+//
+// Distances are calculated with boxDist and, if given, itemDist as in
+// Neighbors, and radius must be in the same units. PlanarBoxDist returns
+// squared distances, so square the radius when using it. Items whose
+// box is farther than radius are skipped without calling itemDist.
+func (r *RTree) SearchRadius(
+	x, y, radius float64,
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+	iterf func(ref int64, dist float64) (next bool),
+) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if boxDist == nil {
+		panic("boxDist nil")
+	}
+
+	if r.count == 0 {
+		return
+	}
+
+	rootNodeIdx := int64(len(r.boxes) - 4)
+	if r.radiusBoxDist(rootNodeIdx, x, y, boxDist) <= radius {
+		r.searchRadius(rootNodeIdx, x, y, radius, boxDist, itemDist, iterf)
+	}
+}
+
+func (r *RTree) searchRadius(
+	nodeIdx int64,
+	x, y, radius float64,
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+	iterf func(ref int64, dist float64) (next bool),
+) bool {
+	var (
+		refIdx       int64 = nodeIdx / 4
+		childNodeIdx int64
+		childRefIdx  int64
+		count        int64 = int64(r.count)
+		dist         float64
+	)
+
+	for childNodeIdx = r.refs[refIdx]; childNodeIdx < r.refs[refIdx+1]; childNodeIdx += 4 {
+		childRefIdx = childNodeIdx / 4
+		if childRefIdx < count {
+			if itemDist != nil {
+				// skip the item distance when the box is already too far
+				if r.radiusBoxDist(childNodeIdx, x, y, boxDist) > radius {
+					continue
+				}
+				dist = itemDist(x, y, r.refs[childRefIdx])
+			} else {
+				dist = r.radiusBoxDist(childNodeIdx, x, y, boxDist)
+			}
+			if dist <= radius && !iterf(r.refs[childRefIdx], dist) {
+				return false
+			}
+		} else if r.radiusBoxDist(childNodeIdx, x, y, boxDist) <= radius {
+			if !r.searchRadius(childNodeIdx, x, y, radius, boxDist, itemDist, iterf) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (r *RTree) radiusBoxDist(
+	nodeIdx int64,
+	x, y float64,
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+) float64 {
+	return boxDist(x, y, r.boxes[nodeIdx], r.boxes[nodeIdx+1], r.boxes[nodeIdx+2], r.boxes[nodeIdx+3])
+}
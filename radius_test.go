@@ -0,0 +1,133 @@
+package flatrtree
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func collectRadius(index *RTree, x, y, radius float64, boxDist func(pX, pY, minX, minY, maxX, maxY float64) float64) []int64 {
+	var refs []int64
+	index.SearchRadius(x, y, radius, boxDist, nil, func(ref int64, dist float64) bool {
+		refs = append(refs, ref)
+		return true
+	})
+	sort.Slice(refs, func(i, j int) bool { return refs[i] < refs[j] })
+	return refs
+}
+
+func TestSearchRadiusPlanar(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, radius := range []float64{0, 5, 20} {
+				var expected []int64
+				for i := 0; i < tc.count; i++ {
+					if PlanarBoxDist(50, 50, tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3]) <= radius*radius {
+						expected = append(expected, int64(i))
+					}
+				}
+				require.Equal(t, expected, collectRadius(tc.index, 50, 50, radius*radius, PlanarBoxDist))
+			}
+		})
+	}
+}
+
+func TestSearchRadiusGeodetic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	var points [][2]float64
+
+	// around the north pole
+	for i := 0; i < 200; i++ {
+		points = append(points, [2]float64{rng.Float64()*360 - 180, 89.9 + rng.Float64()*0.1})
+	}
+
+	// both sides of the antimeridian
+	for i := 0; i < 200; i++ {
+		lon := 179.5 + rng.Float64()*0.5
+		if i%2 == 0 {
+			lon = -lon
+		}
+		points = append(points, [2]float64{lon, rng.Float64() - 0.5})
+	}
+
+	// elsewhere
+	for i := 0; i < 200; i++ {
+		points = append(points, [2]float64{rng.Float64()*360 - 180, rng.Float64()*180 - 90})
+	}
+
+	for builderName, newBuilder := range testBuilders {
+		builder := newBuilder()
+		for i, p := range points {
+			builder.Add(int64(i), p[0], p[1], p[0], p[1])
+		}
+		index, err := builder.Finish(DefaultDegree)
+		require.Nil(t, err)
+
+		for _, q := range []struct {
+			center [2]float64
+			radius float64
+		}{
+			{[2]float64{0, 90}, 5000},
+			{[2]float64{45, 89.95}, 10000},
+			{[2]float64{180, 0}, 30000},
+			{[2]float64{-179.99, 0.2}, 20000},
+			{[2]float64{179.99, -0.2}, 50000},
+		} {
+			var expected []int64
+			for i, p := range points {
+				if haversine(q.center, p) <= q.radius {
+					expected = append(expected, int64(i))
+				}
+			}
+			require.NotEmpty(t, expected)
+
+			// allow for rounding at the edge of the circle
+			actual := collectRadius(index, q.center[0], q.center[1], q.radius, GeodeticBoxDist)
+			for _, ref := range actual {
+				require.LessOrEqual(t, haversine(q.center, points[ref]), q.radius+1e-6, builderName)
+			}
+			require.Equal(t, expected, actual, builderName)
+		}
+	}
+}
+
+func TestSearchRadiusEarlyTermination(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	n := 0
+	index.SearchRadius(50, 50, math.Inf(1), PlanarBoxDist, nil, func(ref int64, dist float64) bool {
+		n++
+		return n < 3
+	})
+	require.Equal(t, 3, n)
+}
+
+func TestSearchRadiusItemDist(t *testing.T) {
+	index, items := createIndex(t, testBuilders["OMT"], 100, DefaultDegree)
+
+	// distance to the far corner of each item
+	itemDist := func(x, y float64, ref int64) float64 {
+		dx, dy := items[ref*4+2]-x, items[ref*4+3]-y
+		return dx*dx + dy*dy
+	}
+
+	var expected []int64
+	for i := 0; i < 100; i++ {
+		if itemDist(50, 50, int64(i)) <= 100 {
+			expected = append(expected, int64(i))
+		}
+	}
+
+	var actual []int64
+	index.SearchRadius(50, 50, 100, PlanarBoxDist, itemDist, func(ref int64, dist float64) bool {
+		require.Equal(t, itemDist(50, 50, ref), dist)
+		actual = append(actual, ref)
+		return true
+	})
+	sort.Slice(actual, func(i, j int) bool { return actual[i] < actual[j] })
+	require.Equal(t, expected, actual)
+}
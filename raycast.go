@@ -0,0 +1,93 @@
+package flatrtree
+
+import (
+	"math"
+
+	"github.com/invisiblefunnel/flatqueue-go/v2"
+)
+
+// Raycast calls the iterf function for all items whose box is hit by the
+// ray from (ox, oy) in direction (dx, dy), in ascending order of tEnter,
+// the parameter at which the ray enters the box. Items containing the
+// origin have a tEnter of 0. Only hits with tEnter <= maxT are reported,
+// so a segment from (ox, oy) to (ox+dx, oy+dy) is cast with a maxT of 1.
+// Pass math.Inf(1) as maxT for an unbounded ray. If iterf returns false
+// the search will terminate.
+func (r *RTree) Raycast(
+	ox, oy, dx, dy, maxT float64,
+	iterf func(ref int64, tEnter float64) (next bool),
+) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if r.count == 0 {
+		return
+	}
+
+	var (
+		queue        flatqueue.FlatQueue[int64, float64]
+		refIdx       int64
+		childRefIdx  int64
+		childNodeIdx int64
+		leafRefIdx   int64
+		t            float64
+		hit          bool
+		count        int64 = int64(r.count)
+	)
+
+	rootNodeIdx := int64(len(r.boxes) - 4)
+	if _, hit = r.slab(rootNodeIdx, ox, oy, dx, dy, maxT); !hit {
+		return
+	}
+
+	rootRefIdx := int64(len(r.refs) - 2)
+	queue.Push(rootRefIdx, 0)
+
+	for queue.Len() > 0 {
+		refIdx = queue.Pop()
+		for childNodeIdx = r.refs[refIdx]; childNodeIdx < r.refs[refIdx+1]; childNodeIdx += 4 {
+			if t, hit = r.slab(childNodeIdx, ox, oy, dx, dy, maxT); hit {
+				childRefIdx = childNodeIdx / 4
+				queue.Push(childRefIdx, t)
+			}
+		}
+
+		for queue.Len() > 0 && queue.Peek() < count {
+			t = queue.PeekValue()
+			leafRefIdx = queue.Pop()
+			if !iterf(r.refs[leafRefIdx], t) {
+				return
+			}
+		}
+	}
+}
+
+// slab returns the parameter at which the ray enters the box,
+// clipped to [0, maxT], and whether it hits the box at all
+func (r *RTree) slab(nodeIdx int64, ox, oy, dx, dy, maxT float64) (float64, bool) {
+	tMin, tMax, hit := clipSlab(ox, dx, r.boxes[nodeIdx], r.boxes[nodeIdx+2], 0, maxT)
+	if !hit {
+		return 0, false
+	}
+
+	tMin, _, hit = clipSlab(oy, dy, r.boxes[nodeIdx+1], r.boxes[nodeIdx+3], tMin, tMax)
+	return tMin, hit
+}
+
+// clipSlab narrows [tMin, tMax] to the parameters where
+// o + t*d is within [lo, hi]
+func clipSlab(o, d, lo, hi, tMin, tMax float64) (float64, float64, bool) {
+	if d == 0 {
+		return tMin, tMax, o >= lo && o <= hi
+	}
+
+	t1, t2 := (lo-o)/d, (hi-o)/d
+	if t1 > t2 {
+		t1, t2 = t2, t1
+	}
+	tMin = math.Max(tMin, t1)
+	tMax = math.Min(tMax, t2)
+
+	return tMin, tMax, tMin <= tMax
+}
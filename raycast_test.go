@@ -0,0 +1,88 @@
+package flatrtree
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bruteRaycast tests one box at a time without the tree
+func bruteRaycast(items []float64, ox, oy, dx, dy, maxT float64) map[int64]float64 {
+	index := &RTree{boxes: items}
+	hits := make(map[int64]float64)
+	for i := 0; i < len(items)/4; i++ {
+		if t, hit := index.slab(int64(i*4), ox, oy, dx, dy, maxT); hit {
+			hits[int64(i)] = t
+		}
+	}
+	return hits
+}
+
+func TestSlab(t *testing.T) {
+	index := &RTree{boxes: []float64{1, 1, 2, 2}}
+
+	tEnter, hit := index.slab(0, 0, 0, 1, 1, math.Inf(1))
+	require.True(t, hit)
+	require.Equal(t, 1.0, tEnter)
+
+	tEnter, hit = index.slab(0, 1.5, 1.5, 1, 0, math.Inf(1))
+	require.True(t, hit)
+	require.Equal(t, 0.0, tEnter)
+
+	tEnter, hit = index.slab(0, 0, 1.5, 4, 0, 1)
+	require.True(t, hit)
+	require.Equal(t, 0.25, tEnter)
+
+	// pointing away, too short, or parallel outside
+	_, hit = index.slab(0, 0, 0, -1, -1, math.Inf(1))
+	require.False(t, hit)
+	_, hit = index.slab(0, 0, 0, 1, 1, 0.5)
+	require.False(t, hit)
+	_, hit = index.slab(0, 0, 0, 1, 0, math.Inf(1))
+	require.False(t, hit)
+}
+
+func TestRaycast(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for q := 0; q < 20; q++ {
+				ox, oy := rng.Float64()*100, rng.Float64()*100
+				angle := rng.Float64() * 2 * math.Pi
+				dx, dy := math.Cos(angle), math.Sin(angle)
+				maxT := math.Inf(1)
+				if q%2 == 0 {
+					maxT = rng.Float64() * 50
+				}
+
+				expected := bruteRaycast(tc.items, ox, oy, dx, dy, maxT)
+
+				var ts []float64
+				tc.index.Raycast(ox, oy, dx, dy, maxT, func(ref int64, tEnter float64) bool {
+					require.Contains(t, expected, ref)
+					require.Equal(t, expected[ref], tEnter)
+					delete(expected, ref)
+					ts = append(ts, tEnter)
+					return true
+				})
+				require.Empty(t, expected)
+				require.True(t, sort.Float64sAreSorted(ts))
+			}
+		})
+	}
+}
+
+func TestRaycastEarlyTermination(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	n := 0
+	index.Raycast(0, 0, 1, 1, math.Inf(1), func(ref int64, tEnter float64) bool {
+		n++
+		return n < 2
+	})
+	require.Equal(t, 2, n)
+}
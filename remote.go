@@ -0,0 +1,494 @@
+package flatrtree
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var _ Index = &RemoteRTree{}
+
+// defaultRemotePageSize is the fetch size used for
+// indexes written without a page size.
+const defaultRemotePageSize = 4096
+
+// defaultRemoteCachePages is the default RemoteOptions.CachePages
+const defaultRemoteCachePages = 1024
+
+// RemoteOptions configures OpenRemoteWithOptions.
+type RemoteOptions struct {
+	// CachePages is the most fetched pages kept in memory. The least
+	// recently used pages are dropped first, so the top levels of the
+	// tree, which every query visits, stay cached. Defaults to 1024.
+	CachePages int
+}
+
+// RemoteRTree queries an index in the fixed-width format through an
+// io.ReaderAt, such as an HTTPRangeReader. Opening the index reads the
+// header and the pages holding the top levels of the tree. Queries then
+// fetch only the pages holding the nodes they visit. Consecutive missing
+// pages are fetched with a single read, and up to RemoteOptions.CachePages
+// fetched pages are cached.
+//
+// Indexes written with FixedOptions.PageSize are fetched in pages of
+// that size, otherwise in pages of 4096 bytes.
+//
+// A RemoteRTree is safe for concurrent queries. Reads are made without
+// holding a lock, and a page is fetched once however many queries are
+// waiting for it.
+type RemoteRTree struct {
+	fixedHeader
+	r         io.ReaderAt
+	fetchSize int64
+
+	mu       sync.Mutex
+	cache    pageCache
+	inflight map[int64]*pageFetch
+}
+
+// pageFetch is a read of n consecutive pages, starting with page
+// start, that other queries needing those pages wait for.
+type pageFetch struct {
+	done     chan struct{}
+	start, n int64
+	buf      []byte
+	err      error
+}
+
+// OpenRemote reads the header of an index in the fixed-width format
+// from r and prefetches the top levels of the tree.
+func OpenRemote(r io.ReaderAt) (*RemoteRTree, error) {
+	return OpenRemoteWithOptions(r, RemoteOptions{})
+}
+
+// OpenRemoteWithOptions is OpenRemote using opts.
+func OpenRemoteWithOptions(r io.ReaderAt, opts RemoteOptions) (*RemoteRTree, error) {
+	if opts.CachePages <= 0 {
+		opts.CachePages = defaultRemoteCachePages
+	}
+
+	var b [fixedHeaderSize]byte
+	if n, err := r.ReadAt(b[:], 0); n < len(b) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	h, err := parseFixedHeader(b[:])
+	if err != nil {
+		return nil, err
+	}
+
+	t := &RemoteRTree{
+		fixedHeader: h,
+		r:           r,
+		fetchSize:   h.pageSize,
+		cache:       newPageCache(opts.CachePages),
+		inflight:    make(map[int64]*pageFetch),
+	}
+	if t.fetchSize == 0 {
+		t.fetchSize = defaultRemotePageSize
+	}
+
+	if h.count == 0 {
+		return t, nil
+	}
+
+	// The root and the levels below it are at the end of each section
+	if _, err := t.read(h.refsOff+8*(h.refsLen-1), 8); err != nil {
+		return nil, err
+	}
+	if _, err := t.read(h.size-4*h.boxSize, 4*h.boxSize); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Count returns the number of items in the index
+func (t *RemoteRTree) Count() int {
+	return int(t.count)
+}
+
+// Search calls the iterf function for all items intersecting the
+// search box. If iterf returns false the search will terminate.
+//
+// Search panics if the underlying reader fails or it visits a corrupt
+// node. Use SearchErr to handle read errors.
+func (t *RemoteRTree) Search(
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64) (next bool),
+) {
+	if err := t.SearchErr(minX, minY, maxX, maxY, iterf); err != nil {
+		panic(err)
+	}
+}
+
+// SearchErr is Search, returning an error instead of panicking if
+// the underlying reader fails or it visits a corrupt node.
+func (t *RemoteRTree) SearchErr(
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64) (next bool),
+) error {
+	return searchSource(t, minX, minY, maxX, maxY, iterf)
+}
+
+// Neighbors calls the iterf function for all items in ascending order of distance
+// to the given coordinates. If iterf returns false the search will terminate.
+//
+// See RTree.Neighbors for a description of boxDist and itemDist. Like
+// Search, Neighbors panics if the underlying reader fails; use
+// NeighborsErr to handle read errors.
+func (t *RemoteRTree) Neighbors(
+	x, y float64,
+	iterf func(ref int64, dist float64) (next bool),
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) {
+	if err := t.NeighborsErr(x, y, iterf, boxDist, itemDist); err != nil {
+		panic(err)
+	}
+}
+
+// NeighborsErr is Neighbors, returning an error instead of panicking
+// if the underlying reader fails or it visits a corrupt node.
+func (t *RemoteRTree) NeighborsErr(
+	x, y float64,
+	iterf func(ref int64, dist float64) (next bool),
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) error {
+	return neighborsSource(t, x, y, iterf, boxDist, itemDist)
+}
+
+func (t *RemoteRTree) numItems() int64 {
+	return t.count
+}
+
+func (t *RemoteRTree) numRefs() int64 {
+	return t.refsLen
+}
+
+func (t *RemoteRTree) readRefs(dst []int64, start int64) error {
+	off, length, err := t.refsSpan(start, int64(len(dst)))
+	if err != nil {
+		return err
+	}
+
+	b, err := t.read(off, length)
+	if err != nil {
+		return err
+	}
+
+	t.decodeRefs(dst, b)
+	return nil
+}
+
+func (t *RemoteRTree) readBoxes(dst []float64, start int64) error {
+	off, length, err := t.boxesSpan(start, int64(len(dst)))
+	if err != nil {
+		return err
+	}
+
+	b, err := t.read(off, length)
+	if err != nil {
+		return err
+	}
+
+	t.decodeBoxes(dst, b)
+	return nil
+}
+
+// read returns length bytes starting at off, fetching missing pages
+func (t *RemoteRTree) read(off, length int64) ([]byte, error) {
+	first := off / t.fetchSize
+	last := (off + length - 1) / t.fetchSize
+
+	pages := make([][]byte, last-first+1)
+	if err := t.fetch(first, pages); err != nil {
+		return nil, err
+	}
+
+	if first == last {
+		start := off - first*t.fetchSize
+		return pages[0][start : start+length], nil
+	}
+
+	b := make([]byte, 0, length)
+	for i, page := range pages {
+		p := first + int64(i)
+		start, end := int64(0), int64(len(page))
+		if p == first {
+			start = off - p*t.fetchSize
+		}
+		if p == last {
+			end = off + length - p*t.fetchSize
+		}
+		b = append(b, page[start:end]...)
+	}
+
+	return b, nil
+}
+
+// fetch fills pages with the pages starting at page first, taking them
+// from the cache, from reads in flight for other queries, or reading
+// runs of consecutive missing pages itself. The lock is not held while
+// reading or waiting.
+func (t *RemoteRTree) fetch(first int64, pages [][]byte) error {
+	var (
+		own  []*pageFetch
+		wait []*pageFetch
+	)
+
+	t.mu.Lock()
+	for i := 0; i < len(pages); i++ {
+		p := first + int64(i)
+		if page, ok := t.cache.get(p); ok {
+			pages[i] = page
+			continue
+		}
+		if f, ok := t.inflight[p]; ok {
+			wait = append(wait, f)
+			continue
+		}
+
+		// extend the run of pages that are neither cached nor in flight
+		end := p
+		for end+1 < first+int64(len(pages)) && !t.cache.has(end+1) && t.inflight[end+1] == nil {
+			end++
+		}
+
+		f := &pageFetch{done: make(chan struct{}), start: p, n: end - p + 1}
+		for q := p; q <= end; q++ {
+			t.inflight[q] = f
+		}
+		own = append(own, f)
+		i += int(end - p)
+	}
+	t.mu.Unlock()
+
+	var err error
+	for _, f := range own {
+		if err != nil {
+			// release waiters without reading once a read has failed
+			f.err = err
+		} else {
+			f.buf, f.err = t.readPages(f.start, f.n)
+		}
+
+		t.mu.Lock()
+		for q := f.start; q < f.start+f.n; q++ {
+			delete(t.inflight, q)
+			if f.err == nil {
+				t.cache.add(q, t.pageOf(f, q))
+			}
+		}
+		t.mu.Unlock()
+		close(f.done)
+
+		if err == nil {
+			err = f.err
+		}
+	}
+
+	for _, f := range wait {
+		<-f.done
+		if err == nil {
+			err = f.err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// pages read here or by other queries are taken from their
+	// fetches, since the cache may already have dropped them
+	for _, f := range append(own, wait...) {
+		for i := range pages {
+			p := first + int64(i)
+			if pages[i] == nil && p >= f.start && p < f.start+f.n {
+				pages[i] = t.pageOf(f, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readPages reads n pages starting at page start
+func (t *RemoteRTree) readPages(start, n int64) ([]byte, error) {
+	off := start * t.fetchSize
+	stop := (start + n) * t.fetchSize
+	if stop > t.size {
+		stop = t.size
+	}
+
+	buf := make([]byte, stop-off)
+	read, err := t.r.ReadAt(buf, off)
+	if read < len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// pageOf returns page p of the pages read by f
+func (t *RemoteRTree) pageOf(f *pageFetch, p int64) []byte {
+	lo := (p - f.start) * t.fetchSize
+	hi := lo + t.fetchSize
+	if hi > int64(len(f.buf)) {
+		hi = int64(len(f.buf))
+	}
+	return f.buf[lo:hi:hi]
+}
+
+// pageCache holds up to max pages, dropping the least recently used
+type pageCache struct {
+	max   int
+	order *list.List
+	pages map[int64]*list.Element
+}
+
+type cachedPage struct {
+	p    int64
+	data []byte
+}
+
+func newPageCache(max int) pageCache {
+	return pageCache{
+		max:   max,
+		order: list.New(),
+		pages: make(map[int64]*list.Element),
+	}
+}
+
+func (c *pageCache) has(p int64) bool {
+	_, ok := c.pages[p]
+	return ok
+}
+
+func (c *pageCache) get(p int64) ([]byte, bool) {
+	e, ok := c.pages[p]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedPage).data, true
+}
+
+func (c *pageCache) add(p int64, data []byte) {
+	if e, ok := c.pages[p]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.pages[p] = c.order.PushFront(&cachedPage{p, data})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.pages, oldest.Value.(*cachedPage).p)
+	}
+}
+
+// HTTPRangeReader is an io.ReaderAt that reads
+// a remote file with HTTP range requests.
+type HTTPRangeReader struct {
+	URL string
+	// Client is used to make requests, http.DefaultClient if nil
+	Client *http.Client
+	// Context is used for requests, context.Background if nil. Since
+	// ReadAt takes no context, set it to cancel the reads of queries.
+	Context context.Context
+}
+
+// ReadAt reads len(p) bytes starting at offset off.
+func (h *HTTPRangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	ctx := h.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	case http.StatusOK:
+		return 0, errors.New("flatrtree: server does not support range requests")
+	default:
+		return 0, fmt.Errorf("flatrtree: unexpected HTTP status %q", resp.Status)
+	}
+
+	// the server may return less than requested at the end of the
+	// file, but the range must start at off
+	start, end, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return 0, err
+	}
+	if start != off || end < start || end-start >= int64(len(p)) {
+		return 0, fmt.Errorf("flatrtree: server returned range %d-%d for %d-%d", start, end, off, off+int64(len(p))-1)
+	}
+
+	want := p[:end-start+1]
+	n, err := io.ReadFull(resp.Body, want)
+	if err == nil && len(want) < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// parseContentRange returns the first and last byte
+// of a Content-Range header such as "bytes 0-99/1000".
+func parseContentRange(header string) (start, end int64, err error) {
+	invalid := fmt.Errorf("flatrtree: invalid Content-Range %q", header)
+
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, 0, invalid
+	}
+	r := strings.TrimPrefix(header, "bytes ")
+
+	dash := strings.IndexByte(r, '-')
+	slash := strings.IndexByte(r, '/')
+	if dash < 0 || slash < dash {
+		return 0, 0, invalid
+	}
+
+	if start, err = strconv.ParseInt(r[:dash], 10, 64); err != nil {
+		return 0, 0, invalid
+	}
+	if end, err = strconv.ParseInt(r[dash+1:slash], 10, 64); err != nil {
+		return 0, 0, invalid
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,357 @@
+package flatrtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serveIndex serves data over HTTP and counts the requests and bytes
+// requested so tests can check how much of the index was fetched.
+func serveIndex(t *testing.T, data []byte) (url string, requests, fetched *int64) {
+	requests, fetched = new(int64), new(int64)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		cw := &countingWriter{ResponseWriter: w, n: fetched}
+		http.ServeContent(cw, r, "index", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, requests, fetched
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func TestRemoteQueries(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := SerializeFixed(tc.index, FixedOptions{PageSize: 64})
+			require.Nil(t, err)
+
+			url, _, _ := serveIndex(t, data)
+			remote, err := OpenRemote(&HTTPRangeReader{URL: url})
+			require.Nil(t, err)
+			require.Equal(t, tc.index.Count(), remote.Count())
+
+			for i := 0; i < tc.count; i++ {
+				var expected, actual []int64
+				tc.index.Search(tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3], func(ref int64) bool {
+					expected = append(expected, ref)
+					return true
+				})
+				err := remote.SearchErr(tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3], func(ref int64) bool {
+					actual = append(actual, ref)
+					return true
+				})
+				require.Nil(t, err)
+				require.Equal(t, expected, actual)
+
+				expected, actual = nil, nil
+				tc.index.Neighbors(tc.items[i*4], tc.items[i*4+1], func(ref int64, dist float64) bool {
+					expected = append(expected, ref)
+					return true
+				}, PlanarBoxDist, nil)
+				err = remote.NeighborsErr(tc.items[i*4], tc.items[i*4+1], func(ref int64, dist float64) bool {
+					actual = append(actual, ref)
+					return true
+				}, PlanarBoxDist, nil)
+				require.Nil(t, err)
+				require.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestRemoteFetchesOnlyVisitedPages(t *testing.T) {
+	builder := NewHilbertBuilder()
+	for i, city := range cities {
+		builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+	}
+
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+
+	for _, pageSize := range []uint32{0, 4096} {
+		data, err := SerializeFixed(index, FixedOptions{Encoding: BoxInt32, Precision: 5, PageSize: pageSize})
+		require.Nil(t, err)
+
+		url, requests, fetched := serveIndex(t, data)
+		remote, err := OpenRemote(&HTTPRangeReader{URL: url})
+		require.Nil(t, err)
+
+		// header and the top of each section
+		require.Equal(t, int64(3), atomic.LoadInt64(requests))
+
+		city := cities[0]
+		var actual []int64
+		err = remote.SearchErr(city.Lon-0.1, city.Lat-0.1, city.Lon+0.1, city.Lat+0.1, func(ref int64) bool {
+			actual = append(actual, ref)
+			return true
+		})
+		require.Nil(t, err)
+		require.Contains(t, actual, int64(0))
+
+		require.Less(t, atomic.LoadInt64(fetched), int64(len(data)/20))
+
+		// repeated queries are served from the cache
+		before := atomic.LoadInt64(requests)
+		err = remote.SearchErr(city.Lon-0.1, city.Lat-0.1, city.Lon+0.1, city.Lat+0.1, func(ref int64) bool {
+			return true
+		})
+		require.Nil(t, err)
+		require.Equal(t, before, atomic.LoadInt64(requests))
+	}
+}
+
+func TestRemotePageAlignment(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	data, err := SerializeFixed(index, FixedOptions{PageSize: 512})
+	require.Nil(t, err)
+
+	h, err := parseFixedHeader(data)
+	require.Nil(t, err)
+	require.Equal(t, int64(512), h.refsOff)
+	require.Equal(t, int64(0), h.boxesOff%512)
+
+	_, err = SerializeFixed(index, FixedOptions{PageSize: 100})
+	require.NotNil(t, err)
+
+	// page aligned files are also valid for in-memory readers
+	fixed, err := DeserializeFixed(data)
+	require.Nil(t, err)
+	require.Equal(t, index.refs, fixed.refs)
+	require.Equal(t, index.boxes, fixed.boxes)
+}
+
+func TestRemoteReaderError(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	data, err := SerializeFixed(index, FixedOptions{PageSize: 64})
+	require.Nil(t, err)
+
+	r := &failingReaderAt{data: data, limit: 3}
+	remote, err := OpenRemote(r)
+	require.Nil(t, err)
+
+	err = remote.SearchErr(0, 0, 100, 100, func(ref int64) bool { return true })
+	require.ErrorIs(t, err, errReadFailed)
+	require.Panics(t, func() {
+		remote.Search(0, 0, 100, 100, func(ref int64) bool { return true })
+	})
+
+	err = remote.NeighborsErr(0, 0, func(ref int64, dist float64) bool { return true }, PlanarBoxDist, nil)
+	require.ErrorIs(t, err, errReadFailed)
+	require.Panics(t, func() {
+		remote.Neighbors(0, 0, func(ref int64, dist float64) bool { return true }, PlanarBoxDist, nil)
+	})
+
+	_, err = OpenRemote(&failingReaderAt{data: data})
+	require.ErrorIs(t, err, errReadFailed)
+}
+
+func TestRemoteCacheLimit(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	boxes := randomBoxes(rng, 2000, 1)
+	index := buildIndex(t, testBuilders["Hilbert"], boxes, DefaultDegree)
+
+	data, err := SerializeFixed(index, FixedOptions{PageSize: 64})
+	require.Nil(t, err)
+
+	remote, err := OpenRemoteWithOptions(bytes.NewReader(data), RemoteOptions{CachePages: 4})
+	require.Nil(t, err)
+
+	for i := 0; i < len(boxes)/4; i += 50 {
+		var expected, actual []int64
+		index.Search(boxes[i*4], boxes[i*4+1], boxes[i*4+2], boxes[i*4+3], func(ref int64) bool {
+			expected = append(expected, ref)
+			return true
+		})
+		err := remote.SearchErr(boxes[i*4], boxes[i*4+1], boxes[i*4+2], boxes[i*4+3], func(ref int64) bool {
+			actual = append(actual, ref)
+			return true
+		})
+		require.Nil(t, err)
+		require.Equal(t, expected, actual)
+		require.LessOrEqual(t, remote.cache.order.Len(), 4)
+	}
+}
+
+func TestRemoteConcurrentQueries(t *testing.T) {
+	index, items := createIndex(t, testBuilders["Hilbert"], 100, 4)
+
+	data, err := SerializeFixed(index, FixedOptions{PageSize: 64})
+	require.Nil(t, err)
+
+	search := func(remote *RemoteRTree, i int) ([]int64, error) {
+		var refs []int64
+		err := remote.SearchErr(items[i*4], items[i*4+1], items[i*4+2], items[i*4+3], func(ref int64) bool {
+			refs = append(refs, ref)
+			return true
+		})
+		return refs, err
+	}
+
+	// reads for one query on its own
+	r := &slowReaderAt{data: data}
+	remote, err := OpenRemote(r)
+	require.Nil(t, err)
+	opened := atomic.LoadInt64(&r.reads)
+	expected, err := search(remote, 0)
+	require.Nil(t, err)
+	single := atomic.LoadInt64(&r.reads) - opened
+
+	// the same query at once fetches each page once, and reads for
+	// different queries overlap rather than waiting for each other
+	r = &slowReaderAt{data: data}
+	remote, err = OpenRemote(r)
+	require.Nil(t, err)
+
+	for _, queries := range [][]int{
+		{0, 0, 0, 0, 0, 0, 0, 0},
+		{10, 20, 30, 40, 50, 60, 70, 80},
+	} {
+		results := make([][]int64, len(queries))
+		errs := make([]error, len(queries))
+
+		var wg sync.WaitGroup
+		for g, i := range queries {
+			wg.Add(1)
+			go func(g, i int) {
+				defer wg.Done()
+				results[g], errs[g] = search(remote, i)
+			}(g, i)
+		}
+		wg.Wait()
+
+		for g, i := range queries {
+			require.Nil(t, errs[g])
+			if i == 0 {
+				require.Equal(t, expected, results[g])
+			}
+		}
+
+		if queries[1] == 0 {
+			require.Equal(t, single, atomic.LoadInt64(&r.reads)-opened)
+		}
+	}
+	require.Greater(t, atomic.LoadInt64(&r.maxActive), int64(1))
+}
+
+// slowReaderAt counts reads and the most reads in progress at once
+type slowReaderAt struct {
+	data      []byte
+	reads     int64
+	active    int64
+	maxActive int64
+}
+
+func (r *slowReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt64(&r.reads, 1)
+	active := atomic.AddInt64(&r.active, 1)
+	defer atomic.AddInt64(&r.active, -1)
+	for {
+		max := atomic.LoadInt64(&r.maxActive)
+		if active <= max || atomic.CompareAndSwapInt64(&r.maxActive, max, active) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	return bytes.NewReader(r.data).ReadAt(p, off)
+}
+
+func TestHTTPRangeReaderContentRange(t *testing.T) {
+	data := []byte("0123456789")
+
+	for _, tc := range []struct {
+		contentRange string
+		valid        bool
+	}{
+		{"bytes 2-5/10", true},
+		{"bytes 3-6/10", false},
+		{"bytes 2-9/10", false},
+		{"bytes 2-5/*", true},
+		{"", false},
+		{"bytes 2/10", false},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", tc.contentRange)
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[2:6])
+		}))
+
+		p := make([]byte, 4)
+		n, err := (&HTTPRangeReader{URL: server.URL}).ReadAt(p, 2)
+		if tc.valid {
+			require.Nil(t, err, tc.contentRange)
+			require.Equal(t, 4, n)
+			require.Equal(t, "2345", string(p))
+		} else {
+			require.NotNil(t, err, tc.contentRange)
+		}
+		server.Close()
+	}
+
+	// a short range at the end of the file
+	url, _, _ := serveIndex(t, data)
+	p := make([]byte, 4)
+	n, err := (&HTTPRangeReader{URL: url}).ReadAt(p, 8)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, "89", string(p[:n]))
+}
+
+func TestHTTPRangeReaderContext(t *testing.T) {
+	url, _, _ := serveIndex(t, []byte("0123456789"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := (&HTTPRangeReader{URL: url, Context: ctx}).ReadAt(make([]byte, 4), 0)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestHTTPRangeReaderNoRangeSupport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not an index"))
+	}))
+	defer server.Close()
+
+	_, err := OpenRemote(&HTTPRangeReader{URL: server.URL})
+	require.NotNil(t, err)
+}
+
+var errReadFailed = errors.New("read failed")
+
+// failingReaderAt fails every read after the first limit reads
+type failingReaderAt struct {
+	data  []byte
+	limit int
+	reads int
+}
+
+func (r *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	if r.reads > r.limit {
+		return 0, errReadFailed
+	}
+	return bytes.NewReader(r.data).ReadAt(p, off)
+}
@@ -4,6 +4,21 @@ import (
 	"github.com/invisiblefunnel/flatqueue-go/v2"
 )
 
+var _ Index = &RTree{}
+
+// Index is the query interface shared by RTree and
+// readers that query an encoded index in place.
+type Index interface {
+	Count() int
+	Search(minX, minY, maxX, maxY float64, iterf func(ref int64) (next bool))
+	Neighbors(
+		x, y float64,
+		iterf func(ref int64, dist float64) (next bool),
+		boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+		itemDist func(pX, pY float64, ref int64) (dist float64),
+	)
+}
+
 type RTree struct {
 	count int
 	refs  []int64
@@ -95,8 +110,55 @@ func (r *RTree) Neighbors(
 		return
 	}
 
+	var queue flatqueue.FlatQueue[int64, float64]
+	r.neighbors(&queue, x, y, iterf, boxDist, itemDist)
+}
+
+// NeighborsOfBox calls the iterf function for all items in ascending order
+// of distance to the given rect. If iterf returns false the search will
+// terminate.
+//
+// Distances are calculated with the rectDist function, such as
+// PlanarRectRectDist or GeodeticRectRectDist. The itemDist function can
+// optionally be supplied to calculate a more accurate distance to items
+// in the index. Take care that itemDist and rectDist return distances in
+// the same units.
+func (r *RTree) NeighborsOfBox(
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64, dist float64) (next bool),
+	rectDist func(aMinX, aMinY, aMaxX, aMaxY, bMinX, bMinY, bMaxX, bMaxY float64) (dist float64),
+	itemDist func(minX, minY, maxX, maxY float64, ref int64) (dist float64),
+) {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if rectDist == nil {
+		panic("rectDist nil")
+	}
+
+	if r.count == 0 {
+		return
+	}
+
+	var refDist func(ref int64) float64
+	if itemDist != nil {
+		refDist = func(ref int64) float64 { return itemDist(minX, minY, maxX, maxY, ref) }
+	}
+
+	var queue flatqueue.FlatQueue[int64, float64]
+	r.boxNeighbors(&queue, minX, minY, maxX, maxY, iterf, rectDist, refDist)
+}
+
+// neighbors implements Neighbors using the given empty queue
+func (r *RTree) neighbors(
+	queue *flatqueue.FlatQueue[int64, float64],
+	x, y float64,
+	iterf func(ref int64, dist float64) (next bool),
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) {
 	var (
-		queue        flatqueue.FlatQueue[int64, float64]
 		refIdx       int64
 		childRefIdx  int64
 		childNodeIdx int64
@@ -133,3 +195,23 @@ func (r *RTree) Neighbors(
 		}
 	}
 }
+
+// boxNeighbors is neighbors with a query box rather than a point
+func (r *RTree) boxNeighbors(
+	queue *flatqueue.FlatQueue[int64, float64],
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64, dist float64) (next bool),
+	rectDist func(aMinX, aMinY, aMaxX, aMaxY, bMinX, bMinY, bMaxX, bMaxY float64) (dist float64),
+	itemDist func(ref int64) (dist float64),
+) {
+	boxDist := func(_, _, nodeMinX, nodeMinY, nodeMaxX, nodeMaxY float64) float64 {
+		return rectDist(minX, minY, maxX, maxY, nodeMinX, nodeMinY, nodeMaxX, nodeMaxY)
+	}
+
+	var pointItemDist func(pX, pY float64, ref int64) float64
+	if itemDist != nil {
+		pointItemDist = func(_, _ float64, ref int64) float64 { return itemDist(ref) }
+	}
+
+	r.neighbors(queue, 0, 0, iterf, boxDist, pointItemDist)
+}
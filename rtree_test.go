@@ -277,3 +277,99 @@ func TestNeighborsByItemDist(t *testing.T) {
 		require.Equal(t, []int64{0, 1}, actual)
 	})
 }
+
+func TestNeighborsOfBox(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < tc.count; i++ {
+				minX, minY := tc.items[i*4]-1, tc.items[i*4+1]-1
+				maxX, maxY := tc.items[i*4+2]+3, tc.items[i*4+3]+3
+
+				var expected []float64
+				expectedRefsByDist := make(map[float64][]int64)
+				for j := 0; j < tc.count; j++ {
+					d := PlanarRectRectDist(
+						minX, minY, maxX, maxY,
+						tc.items[j*4], tc.items[j*4+1], tc.items[j*4+2], tc.items[j*4+3],
+					)
+					expected = append(expected, d)
+					expectedRefsByDist[d] = append(expectedRefsByDist[d], int64(j))
+				}
+				sort.Float64s(expected)
+
+				var actual []float64
+				actualRefsByDist := make(map[float64][]int64)
+				tc.index.NeighborsOfBox(minX, minY, maxX, maxY, func(ref int64, dist float64) bool {
+					actual = append(actual, dist)
+					actualRefsByDist[dist] = append(actualRefsByDist[dist], ref)
+					return true
+				}, PlanarRectRectDist, nil)
+
+				require.Equal(t, expected, actual)
+				require.Equal(t, len(expectedRefsByDist), len(actualRefsByDist))
+
+				for dist := range expectedRefsByDist {
+					require.ElementsMatch(t, expectedRefsByDist[dist], actualRefsByDist[dist])
+				}
+			}
+		})
+	}
+}
+
+func TestNeighborsOfBoxGeodetic(t *testing.T) {
+	builder := NewHilbertBuilder()
+	for i, city := range cities {
+		builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+	}
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+
+	minLon, minLat, maxLon, maxLat := -74.26, 40.49, -73.70, 40.92
+
+	var expected []float64
+	for _, city := range cities {
+		expected = append(expected, GeodeticRectRectDist(
+			minLon, minLat, maxLon, maxLat,
+			city.Lon, city.Lat, city.Lon, city.Lat,
+		))
+	}
+	sort.Float64s(expected)
+	expected = expected[:50]
+
+	var actual []float64
+	index.NeighborsOfBox(minLon, minLat, maxLon, maxLat, func(ref int64, dist float64) bool {
+		actual = append(actual, dist)
+		return len(actual) < 50
+	}, GeodeticRectRectDist, nil)
+
+	require.Equal(t, expected, actual)
+}
+
+func TestNeighborsOfBoxByItemDist(t *testing.T) {
+	index, items := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	// reverse the order of items by distance
+	itemDist := func(minX, minY, maxX, maxY float64, ref int64) float64 {
+		return 1e6 - PlanarRectRectDist(
+			minX, minY, maxX, maxY,
+			items[ref*4], items[ref*4+1], items[ref*4+2], items[ref*4+3],
+		)
+	}
+
+	var actual []float64
+	index.NeighborsOfBox(40, 40, 60, 60, func(ref int64, dist float64) bool {
+		actual = append(actual, dist)
+		return true
+	}, PlanarRectRectDist, itemDist)
+
+	require.Len(t, actual, 100)
+	require.True(t, sort.Float64sAreSorted(actual))
+}
+
+func TestNeighborsOfBoxNilPanics(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+	iterf := func(int64, float64) bool { return true }
+
+	require.Panics(t, func() { index.NeighborsOfBox(0, 0, 1, 1, nil, PlanarRectRectDist, nil) })
+	require.Panics(t, func() { index.NeighborsOfBox(0, 0, 1, 1, iterf, nil, nil) })
+}
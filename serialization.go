@@ -7,14 +7,47 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// Rounding controls how box coordinates are quantized during serialization.
+type Rounding uint8
+
+const (
+	// RoundOutward floors min coordinates and ceils max coordinates so
+	// that every deserialized box contains the box it was created from.
+	// A deserialized index never returns fewer search results than the
+	// index it was serialized from.
+	RoundOutward Rounding = iota
+
+	// RoundNearest rounds every coordinate to the nearest representable
+	// value. Boxes may shrink by up to half a unit of precision.
+	RoundNearest
+)
+
+// SerializeOptions configures SerializeWithOptions.
+type SerializeOptions struct {
+	// Precision is the number of decimal places to keep.
+	Precision uint32
+	// Rounding is the quantization mode, RoundOutward by default.
+	Rounding Rounding
+}
+
+// Serialize encodes the index with the given decimal precision,
+// rounding boxes outward.
 func Serialize(index *RTree, precision uint32) ([]byte, error) {
+	return SerializeWithOptions(index, SerializeOptions{Precision: precision})
+}
+
+// SerializeWithOptions encodes the index using opts.
+func SerializeWithOptions(index *RTree, opts SerializeOptions) ([]byte, error) {
+	if err := checkCount(index.count); err != nil {
+		return nil, err
+	}
 	count := uint32(index.count)
 
-	scale := math.Pow10(int(precision))
+	scale := math.Pow10(int(opts.Precision))
 
 	boxes := make([]int64, len(index.boxes))
 	for i := 0; i < len(index.boxes); i++ {
-		boxes[i] = int64(math.Round(index.boxes[i] * scale))
+		boxes[i] = quantize(index.boxes[i], scale, i%4 < 2, opts.Rounding)
 	}
 
 	// Note: I did not see a performance improvement using
@@ -23,11 +56,58 @@ func Serialize(index *RTree, precision uint32) ([]byte, error) {
 		Count:     count,
 		Refs:      index.refs,
 		Boxes:     boxes,
-		Precision: precision,
+		Precision: opts.Precision,
 	})
 }
 
+// quantize converts a coordinate to an integer at the given scale. With
+// RoundOutward the result, once divided by scale, is guaranteed to be
+// <= v for min coordinates and >= v for max coordinates.
+func quantize(v, scale float64, isMin bool, rounding Rounding) int64 {
+	if rounding == RoundNearest {
+		return int64(math.Round(v * scale))
+	}
+
+	// v * scale is inexact, so step to the tightest
+	// integer that still decodes on the correct side of v
+	if isMin {
+		q := int64(math.Floor(v * scale))
+		if float64(q)/scale > v {
+			q--
+		} else if float64(q+1)/scale <= v {
+			q++
+		}
+		return q
+	}
+
+	q := int64(math.Ceil(v * scale))
+	if float64(q)/scale < v {
+		q++
+	} else if float64(q-1)/scale >= v {
+		q--
+	}
+	return q
+}
+
+// DeserializeOptions configures DeserializeWithOptions.
+type DeserializeOptions struct {
+	// Validate checks the decoded index with RTree.Validate and returns
+	// its *ValidationError if the index is malformed. Without it, only
+	// the layout that queries rely on is checked, and an index with NaN
+	// coordinates or boxes that do not contain their children is
+	// returned, where queries may miss items.
+	Validate bool
+}
+
+// Deserialize decodes data produced by Serialize. It returns a
+// *ValidationError if the layout of the index would make queries panic,
+// but does not check boxes; see DeserializeOptions.Validate.
 func Deserialize(b []byte) (*RTree, error) {
+	return DeserializeWithOptions(b, DeserializeOptions{})
+}
+
+// DeserializeWithOptions decodes data produced by Serialize using opts.
+func DeserializeWithOptions(b []byte, opts DeserializeOptions) (*RTree, error) {
 	msg := &internal.RTree{}
 
 	// Note: vtprotobuf is much faster than stock
@@ -46,9 +126,19 @@ func Deserialize(b []byte) (*RTree, error) {
 		boxes[i] = float64(msgBoxes[i]) / scale
 	}
 
-	return &RTree{
+	index := &RTree{
 		count: count,
 		refs:  msg.GetRefs(),
 		boxes: boxes,
-	}, nil
+	}
+
+	validate := index.checkLayout
+	if opts.Validate {
+		validate = index.Validate
+	}
+	if err := validate(); err != nil {
+		return nil, err
+	}
+
+	return index, nil
 }
@@ -1,7 +1,9 @@
 package flatrtree
 
 import (
+	"fmt"
 	"math"
+	"math/rand"
 	"testing"
 
 	allthecities "github.com/invisiblefunnel/all-the-cities-go"
@@ -90,3 +92,99 @@ func TestDeserializeEmpty(t *testing.T) {
 	require.Equal(t, 0, len(rtree.refs))
 	require.Equal(t, 0, len(rtree.boxes))
 }
+
+func TestSerializeRoundOutwardCities(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for builderName, newBuilder := range testBuilders {
+		builder := newBuilder()
+		for i, city := range cities {
+			builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+		}
+
+		before, err := builder.Finish(DefaultDegree)
+		require.Nil(t, err)
+
+		for prec := uint32(0); prec < 6; prec++ {
+			t.Run(fmt.Sprintf("%v/prec=%d", builderName, prec), func(t *testing.T) {
+				data, err := Serialize(before, prec)
+				require.Nil(t, err)
+
+				after, err := Deserialize(data)
+				require.Nil(t, err)
+
+				// every box grows or stays the same at every level
+				for i := 0; i < len(before.boxes); i += 4 {
+					if after.boxes[i] > before.boxes[i] || after.boxes[i+1] > before.boxes[i+1] ||
+						after.boxes[i+2] < before.boxes[i+2] || after.boxes[i+3] < before.boxes[i+3] {
+						t.Fatalf("box %d shrank: %v -> %v", i/4, before.boxes[i:i+4], after.boxes[i:i+4])
+					}
+				}
+
+				for q := 0; q < 200; q++ {
+					// query edges touch an existing city
+					city := cities[rng.Intn(len(cities))]
+					d := rng.Float64() * 2
+					minX, minY, maxX, maxY := city.Lon, city.Lat, city.Lon+d, city.Lat+d
+					if q%2 == 0 {
+						minX, minY, maxX, maxY = city.Lon-d, city.Lat-d, city.Lon, city.Lat
+					}
+
+					expected := make(map[int64]bool)
+					before.Search(minX, minY, maxX, maxY, func(ref int64) bool {
+						expected[ref] = true
+						return true
+					})
+
+					actual := make(map[int64]bool)
+					after.Search(minX, minY, maxX, maxY, func(ref int64) bool {
+						actual[ref] = true
+						return true
+					})
+
+					for ref := range expected {
+						if !actual[ref] {
+							t.Fatalf("ref %d missing after round trip", ref)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSerializeRoundNearest(t *testing.T) {
+	builder := NewHilbertBuilder()
+	builder.Add(0, 0.14, 0.14, 0.16, 0.16)
+
+	before, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+
+	data, err := SerializeWithOptions(before, SerializeOptions{Precision: 1, Rounding: RoundNearest})
+	require.Nil(t, err)
+
+	after, err := Deserialize(data)
+	require.Nil(t, err)
+	require.Equal(t, []float64{0.1, 0.1, 0.2, 0.2}, after.boxes[:4])
+
+	data, err = SerializeWithOptions(before, SerializeOptions{Precision: 1, Rounding: RoundOutward})
+	require.Nil(t, err)
+
+	after, err = Deserialize(data)
+	require.Nil(t, err)
+	require.Equal(t, []float64{0.1, 0.1, 0.2, 0.2}, after.boxes[:4])
+
+	// a box entirely between two representable values
+	builder = NewHilbertBuilder()
+	builder.Add(0, 0.12, 0.12, 0.13, 0.13)
+
+	before, err = builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+
+	data, err = Serialize(before, 1)
+	require.Nil(t, err)
+
+	after, err = Deserialize(data)
+	require.Nil(t, err)
+	require.Equal(t, []float64{0.1, 0.1, 0.2, 0.2}, after.boxes[:4])
+}
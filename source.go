@@ -0,0 +1,232 @@
+package flatrtree
+
+import (
+	"errors"
+
+	"github.com/invisiblefunnel/flatqueue-go/v2"
+)
+
+// ErrCorruptNode is returned by SearchErr and NeighborsErr on RTreeView,
+// FixedRTree and RemoteRTree when a query visits a node whose refs or
+// boxes are out of range. Search and Neighbors panic with it.
+var ErrCorruptNode = errors.New("flatrtree: corrupt node")
+
+// nodeSource provides access to the refs and boxes of an encoded
+// index without requiring them to be decoded up front. Positions are
+// the same as in RTree: box i is the bounding box for refs[i].
+type nodeSource interface {
+	numItems() int64
+	numRefs() int64
+	// readRefs reads refs[start:start+len(dst)] into dst
+	readRefs(dst []int64, start int64) error
+	// readBoxes reads len(dst)/4 boxes starting at box start into dst
+	readBoxes(dst []float64, start int64) error
+}
+
+// sourceSearch holds per-level buffers so a search allocates
+// at most once for each level of the tree.
+type sourceSearch struct {
+	src                    nodeSource
+	count                  int64
+	minX, minY, maxX, maxY float64
+	iterf                  func(ref int64) (next bool)
+	refs                   [][]int64
+	boxes                  [][]float64
+}
+
+func searchSource(
+	src nodeSource,
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64) (next bool),
+) error {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	count := src.numItems()
+	if count == 0 {
+		return nil
+	}
+
+	rootRefIdx := src.numRefs() - 2
+
+	var root [4]float64
+	if err := src.readBoxes(root[:], rootRefIdx); err != nil {
+		return err
+	}
+	if !boxIntersects(root[:], minX, minY, maxX, maxY) {
+		return nil
+	}
+
+	var rootRefs [2]int64
+	if err := src.readRefs(rootRefs[:], rootRefIdx); err != nil {
+		return err
+	}
+
+	s := sourceSearch{
+		src:   src,
+		count: count,
+		minX:  minX,
+		minY:  minY,
+		maxX:  maxX,
+		maxY:  maxY,
+		iterf: iterf,
+	}
+	_, err := s.search(0, rootRefIdx, rootRefs[0], rootRefs[1])
+	return err
+}
+
+func (s *sourceSearch) search(depth int, refIdx, start, end int64) (bool, error) {
+	if depth == len(s.refs) {
+		s.refs = append(s.refs, nil)
+		s.boxes = append(s.boxes, nil)
+	}
+
+	if !validChildRange(refIdx, start, end) {
+		return false, ErrCorruptNode
+	}
+
+	// children refs plus the one after the last child,
+	// which closes the last child's range
+	firstRefIdx := start / 4
+	n := (end - start) / 4
+	refs := growInt64s(s.refs[depth], int(n+1))
+	boxes := growFloat64s(s.boxes[depth], int(n*4))
+	s.refs[depth] = refs
+	s.boxes[depth] = boxes
+
+	if err := s.src.readBoxes(boxes, firstRefIdx); err != nil {
+		return false, err
+	}
+	if err := s.src.readRefs(refs, firstRefIdx); err != nil {
+		return false, err
+	}
+
+	for i := int64(0); i < n; i++ {
+		if !boxIntersects(boxes[i*4:i*4+4], s.minX, s.minY, s.maxX, s.maxY) {
+			continue
+		}
+
+		if firstRefIdx+i < s.count {
+			if !s.iterf(refs[i]) {
+				return false, nil
+			}
+		} else {
+			next, err := s.search(depth+1, firstRefIdx+i, refs[i], refs[i+1])
+			if !next || err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func neighborsSource(
+	src nodeSource,
+	x, y float64,
+	iterf func(ref int64, dist float64) (next bool),
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) error {
+	if iterf == nil {
+		panic("iterf nil")
+	}
+
+	if boxDist == nil {
+		panic("boxDist nil")
+	}
+
+	count := src.numItems()
+	if count == 0 {
+		return nil
+	}
+
+	var (
+		queue       flatqueue.FlatQueue[int64, float64]
+		refIdx      int64
+		childRefIdx int64
+		leafRefIdx  int64
+		dist        float64
+		bounds      [2]int64
+		leafRef     [1]int64
+		refs        []int64
+		boxes       []float64
+	)
+
+	rootRefIdx := src.numRefs() - 2
+	queue.Push(rootRefIdx, 0)
+
+	for queue.Len() > 0 {
+		refIdx = queue.Pop()
+		if err := src.readRefs(bounds[:], refIdx); err != nil {
+			return err
+		}
+
+		if !validChildRange(refIdx, bounds[0], bounds[1]) {
+			return ErrCorruptNode
+		}
+
+		firstRefIdx := bounds[0] / 4
+		n := (bounds[1] - bounds[0]) / 4
+		refs = growInt64s(refs, int(n))
+		boxes = growFloat64s(boxes, int(n*4))
+
+		if err := src.readBoxes(boxes, firstRefIdx); err != nil {
+			return err
+		}
+		if itemDist != nil && firstRefIdx < count {
+			if err := src.readRefs(refs, firstRefIdx); err != nil {
+				return err
+			}
+		}
+
+		for i := int64(0); i < n; i++ {
+			childRefIdx = firstRefIdx + i
+			if childRefIdx < count && itemDist != nil {
+				dist = itemDist(x, y, refs[i])
+			} else {
+				dist = boxDist(x, y, boxes[i*4], boxes[i*4+1], boxes[i*4+2], boxes[i*4+3])
+			}
+			queue.Push(childRefIdx, dist)
+		}
+
+		for queue.Len() > 0 && queue.Peek() < count {
+			dist = queue.PeekValue()
+			leafRefIdx = queue.Pop()
+			if err := src.readRefs(leafRef[:], leafRefIdx); err != nil {
+				return err
+			}
+			if !iterf(leafRef[0], dist) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// validChildRange reports whether [start, end) is a non-empty range of
+// boxes that precedes the node at refIdx. Children always come before
+// their parent, which rules out cycles in corrupt input.
+func validChildRange(refIdx, start, end int64) bool {
+	return start >= 0 && start < end && end <= refIdx*4 && start%4 == 0 && end%4 == 0
+}
+
+func boxIntersects(box []float64, minX, minY, maxX, maxY float64) bool {
+	return !(maxX < box[0] || maxY < box[1] || minX > box[2] || minY > box[3])
+}
+
+func growInt64s(s []int64, n int) []int64 {
+	if cap(s) < n {
+		return make([]int64, n)
+	}
+	return s[:n]
+}
+
+func growFloat64s(s []float64, n int) []float64 {
+	if cap(s) < n {
+		return make([]float64, n)
+	}
+	return s[:n]
+}
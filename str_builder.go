@@ -0,0 +1,221 @@
+package flatrtree
+
+import (
+	"math"
+	"sort"
+)
+
+var _ Builder = &STRBuilder{}
+
+// STRBuilder bulk loads an index with the Sort-Tile-Recursive algorithm,
+// grouping nodes the same way as the JTS STRtree. Each level is sorted by
+// the x coordinate of box centers and cut into vertical slices, which are
+// sorted by the y coordinate of box centers and cut into nodes of degree
+// children.
+type STRBuilder struct {
+	count int
+	refs  []int64
+	boxes []float64
+
+	// buffers kept from the previous Finish for reuse after Reset
+	spareRefs  []int64
+	spareBoxes []float64
+}
+
+func NewSTRBuilder() *STRBuilder {
+	return &STRBuilder{}
+}
+
+func (b *STRBuilder) Add(ref int64, minX, minY, maxX, maxY float64) {
+	b.count++
+	b.refs = append(b.refs, ref)
+	b.boxes = append(b.boxes, minX, minY, maxX, maxY)
+}
+
+// AddBoxes adds items with refs[i] and the box at boxes[i*4:i*4+4].
+// It panics if len(boxes) != 4*len(refs).
+func (b *STRBuilder) AddBoxes(refs []int64, boxes []float64) {
+	if len(boxes) != 4*len(refs) {
+		panic("len(boxes) != 4*len(refs)")
+	}
+
+	b.count += len(refs)
+	b.refs = append(b.refs, refs...)
+	b.boxes = append(b.boxes, boxes...)
+}
+
+// Grow grows the builder's capacity to add n more items without allocating.
+func (b *STRBuilder) Grow(n int) {
+	b.refs = growSlice(b.refs, n)
+	b.boxes = growSlice(b.boxes, 4*n)
+}
+
+// Reset removes all items so the builder can be reused, keeping its
+// buffers. An index returned by a previous Finish shares these buffers
+// and must not be used after Reset.
+func (b *STRBuilder) Reset() {
+	b.count = 0
+	b.refs = b.refs[:0]
+	b.boxes = b.boxes[:0]
+}
+
+func (b *STRBuilder) Finish(degree int) (*RTree, error) {
+	if degree < 2 {
+		return nil, ErrInvalidDegree
+	}
+
+	return b.FinishWithOptions(BuildOptions{LeafDegree: degree, NodeDegree: degree})
+}
+
+// FinishWithOptions is Finish with separate degrees for leaves and the
+// nodes above them.
+func (b *STRBuilder) FinishWithOptions(opts BuildOptions) (*RTree, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	if b.count == 0 {
+		return &RTree{}, nil
+	}
+
+	if err := checkCount(b.count); err != nil {
+		return nil, err
+	}
+
+	if len(b.refs) != b.count {
+		return nil, ErrFinishCalledTwice
+	}
+
+	if opts.Strict {
+		if err := checkBoxes(b.refs, b.boxes); err != nil {
+			return nil, err
+		}
+	}
+
+	var levels []strLevel
+	boxes := b.boxes
+	for {
+		level := newSTRLevel(boxes, opts.degree(len(levels)))
+		levels = append(levels, level)
+		boxes = level.boxes
+		if len(level.children) == 1 {
+			break
+		}
+	}
+
+	b.pack(levels)
+
+	return &RTree{
+		count: b.count,
+		refs:  b.refs,
+		boxes: b.boxes,
+	}, nil
+}
+
+// strLevel holds the nodes grouping the boxes of the level below.
+// Nodes and boxes are numbered in the order they were created.
+type strLevel struct {
+	// children lists the positions of each node's children on the level below
+	children [][]int
+	boxes    []float64
+}
+
+// newSTRLevel groups the given boxes into nodes of at most degree children.
+func newSTRLevel(boxes []float64, degree int) strLevel {
+	n := len(boxes) / 4
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+
+	centerX := func(i int) float64 { return (boxes[i*4] + boxes[i*4+2]) / 2 }
+	centerY := func(i int) float64 { return (boxes[i*4+1] + boxes[i*4+3]) / 2 }
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return centerX(order[i]) < centerX(order[j])
+	})
+
+	minNodeCount := int(math.Ceil(float64(n) / float64(degree)))
+	sliceCount := int(math.Ceil(math.Sqrt(float64(minNodeCount))))
+	sliceCapacity := int(math.Ceil(float64(n) / float64(sliceCount)))
+
+	var level strLevel
+
+	for sliceStart := 0; sliceStart < n; sliceStart += sliceCapacity {
+		sliceEnd := sliceStart + sliceCapacity
+		if sliceEnd > n {
+			sliceEnd = n
+		}
+
+		slice := order[sliceStart:sliceEnd]
+		sort.SliceStable(slice, func(i, j int) bool {
+			return centerY(slice[i]) < centerY(slice[j])
+		})
+
+		for childStart := 0; childStart < len(slice); childStart += degree {
+			childEnd := childStart + degree
+			if childEnd > len(slice) {
+				childEnd = len(slice)
+			}
+
+			minX, minY := math.Inf(1), math.Inf(1)
+			maxX, maxY := math.Inf(-1), math.Inf(-1)
+			for _, i := range slice[childStart:childEnd] {
+				minX = math.Min(minX, boxes[i*4])
+				minY = math.Min(minY, boxes[i*4+1])
+				maxX = math.Max(maxX, boxes[i*4+2])
+				maxY = math.Max(maxY, boxes[i*4+3])
+			}
+
+			level.children = append(level.children, slice[childStart:childEnd])
+			level.boxes = append(level.boxes, minX, minY, maxX, maxY)
+		}
+	}
+
+	return level
+}
+
+// pack flattens the tree from the root down so the children of each
+// node are contiguous, then writes the levels from the items up.
+func (b *STRBuilder) pack(levels []strLevel) {
+	height := len(levels)
+
+	// order[level] lists the boxes on level, by creation
+	// number, in the order they are written
+	order := make([][]int, height+1)
+	order[height] = []int{0}
+	for level := height - 1; level >= 0; level-- {
+		for _, node := range order[level+1] {
+			order[level] = append(order[level], levels[level].children[node]...)
+		}
+	}
+
+	numBoxes := b.count
+	for _, level := range levels {
+		numBoxes += len(level.children)
+	}
+
+	refs := reuseSlice(b.spareRefs, numBoxes+1)[:0]
+	boxes := reuseSlice(b.spareBoxes, numBoxes*4)[:0]
+	for _, i := range order[0] {
+		refs = append(refs, b.refs[i])
+		boxes = append(boxes, b.boxes[i*4:i*4+4]...)
+	}
+
+	var ref int64
+	refs = append(refs, ref)
+	for level := 0; level < height; level++ {
+		for _, node := range order[level+1] {
+			ref += int64(4 * len(levels[level].children[node]))
+			refs = append(refs, ref)
+			boxes = append(boxes, levels[level].boxes[node*4:node*4+4]...)
+		}
+	}
+
+	b.spareRefs = b.refs
+	b.spareBoxes = b.boxes
+	b.refs = refs
+	b.boxes = boxes
+}
@@ -0,0 +1,331 @@
+package flatrtree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// streamBufferSize bounds the memory used to
+// buffer reads and writes while streaming.
+const streamBufferSize = 64 * 1024
+
+// SerializeTo writes the same bytes as Serialize to w without holding
+// the encoded index in memory.
+func SerializeTo(w io.Writer, index *RTree, precision uint32) error {
+	return SerializeToWithOptions(w, index, SerializeOptions{Precision: precision})
+}
+
+// SerializeToWithOptions writes the same bytes as SerializeWithOptions
+// to w without holding the encoded index in memory.
+func SerializeToWithOptions(w io.Writer, index *RTree, opts SerializeOptions) error {
+	if err := checkCount(index.count); err != nil {
+		return err
+	}
+
+	scale := math.Pow10(int(opts.Precision))
+
+	pw := protoWriter{w: bufio.NewWriterSize(w, streamBufferSize)}
+
+	if index.count != 0 {
+		pw.tag(1, protowire.VarintType)
+		pw.varint(uint64(uint32(index.count)))
+	}
+
+	if len(index.refs) > 0 {
+		size := 0
+		for _, ref := range index.refs {
+			size += protowire.SizeVarint(uint64(ref))
+		}
+
+		pw.tag(2, protowire.BytesType)
+		pw.varint(uint64(size))
+		for _, ref := range index.refs {
+			pw.varint(uint64(ref))
+		}
+	}
+
+	if len(index.boxes) > 0 {
+		// quantize twice rather than buffering the quantized boxes
+		size := 0
+		for i, coord := range index.boxes {
+			q := quantize(coord, scale, i%4 < 2, opts.Rounding)
+			size += protowire.SizeVarint(protowire.EncodeZigZag(q))
+		}
+
+		pw.tag(3, protowire.BytesType)
+		pw.varint(uint64(size))
+		for i, coord := range index.boxes {
+			q := quantize(coord, scale, i%4 < 2, opts.Rounding)
+			pw.varint(protowire.EncodeZigZag(q))
+		}
+	}
+
+	if opts.Precision != 0 {
+		pw.tag(4, protowire.VarintType)
+		pw.varint(uint64(opts.Precision))
+	}
+
+	return pw.w.Flush()
+}
+
+// protoWriter writes protobuf wire format values. Errors are
+// retained by the bufio.Writer and reported by Flush.
+type protoWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (p *protoWriter) tag(num protowire.Number, typ protowire.Type) {
+	p.w.Write(protowire.AppendTag(p.buf[:0], num, typ))
+}
+
+func (p *protoWriter) varint(v uint64) {
+	p.w.Write(protowire.AppendVarint(p.buf[:0], v))
+}
+
+// DeserializeFrom reads an index written by Serialize or SerializeTo
+// from r. The packed refs and boxes are decoded as they are read, so
+// the encoded index is never held in memory. Like Deserialize, it checks
+// the layout of the index but not its boxes.
+func DeserializeFrom(r io.Reader) (*RTree, error) {
+	pr := protoReader{r: bufio.NewReaderSize(r, streamBufferSize)}
+
+	var (
+		count     uint32
+		precision uint32
+		// Refs are read in chunks, since sizing them from the stream
+		// could allocate far more than it holds, and growing a slice
+		// copies it. Boxes read after the refs are sized from them.
+		refs       chunks[int64]
+		boxes      []float64
+		boxChunks  chunks[float64]
+		boxesSized bool
+	)
+
+	addBox := func(v uint64) {
+		box := float64(protowire.DecodeZigZag(v))
+		if boxesSized {
+			boxes = append(boxes, box)
+		} else {
+			boxChunks.append(box)
+		}
+	}
+
+	for {
+		key, err := pr.varint()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		num, typ := protowire.Number(key>>3), protowire.Type(key&7)
+
+		var v uint64
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, err = pr.varint()
+			count = uint32(v)
+		case num == 4 && typ == protowire.VarintType:
+			v, err = pr.varint()
+			precision = uint32(v)
+		case num == 2 && typ == protowire.VarintType:
+			v, err = pr.varint()
+			refs.append(int64(v))
+		case num == 3 && typ == protowire.VarintType:
+			v, err = pr.varint()
+			addBox(v)
+		case num == 2 && typ == protowire.BytesType:
+			err = pr.packed(nil, func(v uint64) {
+				refs.append(int64(v))
+			})
+		case num == 3 && typ == protowire.BytesType:
+			err = pr.packed(func(length int) {
+				if !boxesSized && boxChunks.len() == 0 && refs.len() > 1 {
+					boxes = make([]float64, 0, 4*(refs.len()-1))
+					boxesSized = true
+				}
+			}, addBox)
+		default:
+			err = pr.skip(typ)
+		}
+		if err != nil {
+			return nil, noEOF(err)
+		}
+	}
+
+	if boxChunks.len() > 0 {
+		boxes = boxChunks.join()
+	}
+
+	// precision may follow the boxes, so scale them once everything is read
+	scale := math.Pow10(int(precision))
+	for i := range boxes {
+		boxes[i] /= scale
+	}
+
+	index := &RTree{
+		count: int(count),
+		refs:  refs.join(),
+		boxes: boxes,
+	}
+	if err := index.checkLayout(); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// chunks collects values in chunks that double in size up to
+// streamBufferSize, so reading a value never copies the ones before it.
+type chunks[T any] struct {
+	full [][]T
+	last []T
+	n    int
+}
+
+func (c *chunks[T]) append(v T) {
+	if len(c.last) == cap(c.last) {
+		if c.last != nil {
+			c.full = append(c.full, c.last)
+		}
+		size := 1024
+		if c.last != nil {
+			size = 2 * cap(c.last)
+		}
+		if size > streamBufferSize {
+			size = streamBufferSize
+		}
+		c.last = make([]T, 0, size)
+	}
+	c.last = append(c.last, v)
+	c.n++
+}
+
+func (c *chunks[T]) len() int {
+	return c.n
+}
+
+// join returns the values in a slice of their exact length, or nil
+// if there are none, releasing each chunk once it is copied.
+func (c *chunks[T]) join() []T {
+	if c.n == 0 {
+		return nil
+	}
+
+	values := make([]T, 0, c.n)
+	for i, chunk := range c.full {
+		values = append(values, chunk...)
+		c.full[i] = nil
+	}
+	values = append(values, c.last...)
+
+	*c = chunks[T]{}
+	return values
+}
+
+// protoReader reads protobuf wire format values.
+type protoReader struct {
+	r *bufio.Reader
+	// n counts bytes read so packed fields can stop at their length
+	n int
+}
+
+func (p *protoReader) varint() (uint64, error) {
+	var v uint64
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		c, err := p.r.ReadByte()
+		if err != nil {
+			if i > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		p.n++
+
+		if i == binary.MaxVarintLen64-1 && c > 1 {
+			break
+		}
+
+		v |= uint64(c&0x7f) << (7 * i)
+		if c < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("flatrtree: varint overflow")
+}
+
+// packed calls start, if not nil, with the length in bytes of a packed
+// field, then f with each of its values.
+func (p *protoReader) packed(start func(length int), f func(v uint64)) error {
+	length, err := p.varint()
+	if err != nil {
+		return err
+	}
+
+	if length > uint64(math.MaxInt-p.n) {
+		return errors.New("flatrtree: packed field too large")
+	}
+	end := p.n + int(length)
+	if start != nil {
+		start(int(length))
+	}
+
+	for p.n < end {
+		v, err := p.varint()
+		if err != nil {
+			return err
+		}
+		f(v)
+	}
+
+	if p.n != end {
+		return errors.New("flatrtree: packed field length mismatch")
+	}
+
+	return nil
+}
+
+func (p *protoReader) skip(typ protowire.Type) error {
+	var n uint64
+	switch typ {
+	case protowire.VarintType:
+		_, err := p.varint()
+		return err
+	case protowire.Fixed32Type:
+		n = 4
+	case protowire.Fixed64Type:
+		n = 8
+	case protowire.BytesType:
+		length, err := p.varint()
+		if err != nil {
+			return err
+		}
+		n = length
+	default:
+		return errors.New("flatrtree: unsupported wire type")
+	}
+
+	if n > math.MaxInt32 {
+		return errors.New("flatrtree: field too large")
+	}
+
+	skipped, err := p.r.Discard(int(n))
+	p.n += skipped
+	return err
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF for
+// reads that end partway through a field.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,216 @@
+package flatrtree
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestSerializeToMatchesSerialize(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, prec := range []uint32{0, 1, 7} {
+				expected, err := Serialize(tc.index, prec)
+				require.Nil(t, err)
+
+				var buf bytes.Buffer
+				require.Nil(t, SerializeTo(&buf, tc.index, prec))
+				require.True(t, bytes.Equal(expected, buf.Bytes()))
+			}
+		})
+	}
+}
+
+func TestSerializeToWithOptions(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	for _, rounding := range []Rounding{RoundOutward, RoundNearest} {
+		opts := SerializeOptions{Precision: 1, Rounding: rounding}
+		expected, err := SerializeWithOptions(index, opts)
+		require.Nil(t, err)
+
+		var buf bytes.Buffer
+		require.Nil(t, SerializeToWithOptions(&buf, index, opts))
+		require.True(t, bytes.Equal(expected, buf.Bytes()))
+	}
+}
+
+func TestSerializeToTooManyItems(t *testing.T) {
+	if strconv.IntSize < 64 {
+		t.Skip("count cannot exceed MaxUint32")
+	}
+
+	count := uint64(math.MaxUint32) + 1
+	err := SerializeTo(io.Discard, &RTree{count: int(count)}, 0)
+	require.ErrorIs(t, err, ErrTooManyItems)
+}
+
+func TestDeserializeFromMatchesDeserialize(t *testing.T) {
+	builder := NewHilbertBuilder()
+	for i, city := range cities {
+		builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+	}
+
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	require.Nil(t, SerializeTo(&buf, index, 5))
+
+	expected, err := Deserialize(buf.Bytes())
+	require.Nil(t, err)
+
+	actual, err := DeserializeFrom(bytes.NewReader(buf.Bytes()))
+	require.Nil(t, err)
+
+	require.Equal(t, expected.count, actual.count)
+	require.Equal(t, expected.refs, actual.refs)
+	require.Equal(t, expected.boxes, actual.boxes)
+}
+
+func TestDeserializeFromAllocs(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, 4)
+
+	var buf bytes.Buffer
+	require.Nil(t, SerializeTo(&buf, index, 1))
+	data := buf.Bytes()
+
+	// refs are copied once from their chunks, and boxes
+	// are allocated once from the refs
+	allocs := testing.AllocsPerRun(10, func() {
+		_, err := DeserializeFrom(bytes.NewReader(data))
+		require.Nil(t, err)
+	})
+	require.LessOrEqual(t, allocs, 6.0)
+}
+
+func TestDeserializeFromRoundTrip(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.Nil(t, SerializeTo(&buf, tc.index, 1))
+
+			// exercise reads that return one byte at a time
+			actual, err := DeserializeFrom(iotest.OneByteReader(&buf))
+			require.Nil(t, err)
+
+			require.Equal(t, tc.index.count, actual.count)
+			require.Equal(t, len(tc.index.refs), len(actual.refs))
+			require.Equal(t, len(tc.index.boxes), len(actual.boxes))
+			for i := range tc.index.refs {
+				require.Equal(t, tc.index.refs[i], actual.refs[i])
+			}
+			for i := range tc.index.boxes {
+				require.Equal(t, tc.index.boxes[i], actual.boxes[i])
+			}
+		})
+	}
+}
+
+func TestDeserializeFromPrecisionFirst(t *testing.T) {
+	// fields may appear in any order
+	data := []byte{
+		0x20, 0x01, // precision = 1
+		0x08, 0x01, // count = 1
+		0x12, 0x03, 0x07, 0x00, 0x04, // refs = [7, 0, 4]
+		0x1a, 0x08, 0x02, 0x04, 0x06, 0x08, 0x02, 0x04, 0x06, 0x08, // boxes
+	}
+
+	expected, err := Deserialize(data)
+	require.Nil(t, err)
+
+	actual, err := DeserializeFrom(bytes.NewReader(data))
+	require.Nil(t, err)
+
+	require.Equal(t, expected.count, actual.count)
+	require.Equal(t, expected.refs, actual.refs)
+	require.Equal(t, expected.boxes, actual.boxes)
+	require.Equal(t, []float64{0.1, 0.2, 0.3, 0.4, 0.1, 0.2, 0.3, 0.4}, actual.boxes)
+}
+
+func TestDeserializeFromBoxesFirst(t *testing.T) {
+	// enough boxes to fill several chunks before the refs are read
+	rng := rand.New(rand.NewSource(1))
+	index := buildIndex(t, testBuilders["Hilbert"], randomBoxes(rng, 50000, 1), DefaultDegree)
+
+	data, err := Serialize(index, 3)
+	require.Nil(t, err)
+
+	var fields [][]byte
+	for rest := data; len(rest) > 0; {
+		_, _, n := protowire.ConsumeField(rest)
+		require.Greater(t, n, 0)
+		fields = append(fields, rest[:n])
+		rest = rest[n:]
+	}
+
+	var reversed []byte
+	for i := len(fields) - 1; i >= 0; i-- {
+		reversed = append(reversed, fields[i]...)
+	}
+
+	expected, err := Deserialize(data)
+	require.Nil(t, err)
+
+	actual, err := DeserializeFrom(bytes.NewReader(reversed))
+	require.Nil(t, err)
+
+	require.Equal(t, expected.count, actual.count)
+	require.Equal(t, expected.refs, actual.refs)
+	require.Equal(t, expected.boxes, actual.boxes)
+}
+
+func TestDeserializeFromTruncated(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	var buf bytes.Buffer
+	require.Nil(t, SerializeTo(&buf, index, 1))
+	data := buf.Bytes()
+
+	for _, n := range []int{1, 3, len(data) / 2, len(data) - 1} {
+		_, err := DeserializeFrom(bytes.NewReader(data[:n]))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDeserializeFromHugeCount(t *testing.T) {
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.VarintType)
+	data = protowire.AppendVarint(data, math.MaxUint32)
+	data = protowire.AppendTag(data, 2, protowire.BytesType)
+	data = protowire.AppendVarint(data, 1<<40)
+	data = protowire.AppendVarint(data, 0)
+
+	_, err := DeserializeFrom(bytes.NewReader(data))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestDeserializeFromReaderError(t *testing.T) {
+	readErr := errors.New("read failed")
+	_, err := DeserializeFrom(iotest.ErrReader(readErr))
+	require.ErrorIs(t, err, readErr)
+}
+
+func TestSerializeToWriterError(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	writeErr := errors.New("write failed")
+	err := SerializeTo(failingWriter{writeErr}, index, 1)
+	require.ErrorIs(t, err, writeErr)
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
@@ -12,6 +12,7 @@ var testDegrees = []int{5, DefaultDegree, 15}
 var testBuilders = map[string]func() Builder{
 	"Hilbert": func() Builder { return NewHilbertBuilder() },
 	"OMT":     func() Builder { return NewOMTBuilder() },
+	"STR":     func() Builder { return NewSTRBuilder() },
 }
 
 type rtreeTestCase struct {
@@ -36,7 +37,7 @@ func createTestCases(t *testing.T) []rtreeTestCase {
 	return result
 }
 
-func createIndex(t *testing.T, newBuilder func() Builder, count int, degree int) (*RTree, []float64) {
+func createIndex(t testing.TB, newBuilder func() Builder, count int, degree int) (*RTree, []float64) {
 	var testBoxes = []float64{
 		8, 62, 11, 66, 57, 17, 57, 19, 76, 26, 79, 29, 36, 56, 38, 56, 92, 77, 96, 80, 87, 70, 90, 74,
 		43, 41, 47, 43, 0, 58, 2, 62, 76, 86, 80, 89, 27, 13, 27, 15, 71, 63, 75, 67, 25, 2, 27, 2, 87,
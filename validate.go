@@ -0,0 +1,107 @@
+package flatrtree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Validation failures, wrapped in a *ValidationError.
+// Use errors.Is to check which one occurred.
+var (
+	// ErrInvalidLayout means count, refs and boxes disagree in length
+	ErrInvalidLayout = errors.New("invalid layout")
+	// ErrInvalidChildren means a node's children are out of range, out
+	// of order, or not each the child of exactly one node
+	ErrInvalidChildren = errors.New("invalid child offsets")
+	// ErrNotContained means a node's box does not contain a child's box
+	ErrNotContained = errors.New("box does not contain child")
+	// ErrNaNCoordinate means a box has a NaN coordinate
+	ErrNaNCoordinate = errors.New("NaN coordinate")
+)
+
+// ValidationError describes the first problem found by Validate.
+type ValidationError struct {
+	// Err is one of the validation errors above
+	Err error
+	// Box is the position of the offending box in the
+	// index, or -1 for problems with the overall layout
+	Box int
+}
+
+func (e *ValidationError) Error() string {
+	if e.Box < 0 {
+		return fmt.Sprintf("flatrtree: %v", e.Err)
+	}
+	return fmt.Sprintf("flatrtree: %v at box %d", e.Err, e.Box)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks that the index is well formed so queries cannot panic
+// or loop, and that boxes have no NaN coordinates and contain their
+// children, for indexes decoded from untrusted input. It returns a
+// *ValidationError describing the first problem found.
+func (r *RTree) Validate() error {
+	if err := r.checkLayout(); err != nil {
+		return err
+	}
+
+	for i, coord := range r.boxes {
+		if math.IsNaN(coord) {
+			return &ValidationError{ErrNaNCoordinate, i / 4}
+		}
+	}
+
+	for refIdx := r.count; refIdx < len(r.refs)-1; refIdx++ {
+		nodeIdx := refIdx * 4
+		for childIdx := r.refs[refIdx]; childIdx < r.refs[refIdx+1]; childIdx += 4 {
+			if r.boxes[childIdx] < r.boxes[nodeIdx] ||
+				r.boxes[childIdx+1] < r.boxes[nodeIdx+1] ||
+				r.boxes[childIdx+2] > r.boxes[nodeIdx+2] ||
+				r.boxes[childIdx+3] > r.boxes[nodeIdx+3] {
+				return &ValidationError{ErrNotContained, refIdx}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkLayout is the part of Validate that queries rely on: the lengths
+// of refs and boxes and the child offsets of every node. It only reads
+// refs, so decoding runs it on every index.
+func (r *RTree) checkLayout() error {
+	if r.count == 0 {
+		if len(r.refs) != 0 || len(r.boxes) != 0 {
+			return &ValidationError{ErrInvalidLayout, -1}
+		}
+		return nil
+	}
+
+	if r.count < 0 || len(r.refs) < r.count+2 || len(r.boxes) != 4*(len(r.refs)-1) {
+		return &ValidationError{ErrInvalidLayout, -1}
+	}
+
+	// Children of consecutive nodes are consecutive, so every box but the
+	// root is the child of exactly one node if the first node starts at
+	// the first box and the root ends where it begins.
+	numBoxes := len(r.refs) - 1
+	if r.refs[r.count] != 0 {
+		return &ValidationError{ErrInvalidChildren, r.count}
+	}
+	if r.refs[numBoxes] != int64(4*(numBoxes-1)) {
+		return &ValidationError{ErrInvalidChildren, numBoxes - 1}
+	}
+
+	for refIdx := r.count; refIdx < numBoxes; refIdx++ {
+		// children come before their parent and ends are exclusive
+		if !validChildRange(int64(refIdx), r.refs[refIdx], r.refs[refIdx+1]) {
+			return &ValidationError{ErrInvalidChildren, refIdx}
+		}
+	}
+
+	return nil
+}
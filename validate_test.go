@@ -0,0 +1,107 @@
+package flatrtree
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/flatrtree/flatrtree-go/internal"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestValidateBuilt(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Nil(t, tc.index.Validate())
+
+			data, err := Serialize(tc.index, 0)
+			require.Nil(t, err)
+
+			index, err := DeserializeWithOptions(data, DeserializeOptions{Validate: true})
+			require.Nil(t, err)
+			require.Equal(t, tc.index.count, index.count)
+		})
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	valid := func() *RTree {
+		// two leaf nodes under a root
+		return &RTree{
+			count: 3,
+			refs:  []int64{0, 1, 2, 0, 8, 12, 20},
+			boxes: []float64{
+				0, 0, 1, 1,
+				2, 2, 3, 3,
+				4, 4, 5, 5,
+				0, 0, 3, 3,
+				4, 4, 5, 5,
+				0, 0, 5, 5,
+			},
+		}
+	}
+	require.Nil(t, valid().Validate())
+
+	for _, tc := range []struct {
+		name   string
+		mutate func(r *RTree)
+		err    error
+		box    int
+	}{
+		{"empty with refs", func(r *RTree) { r.count = 0 }, ErrInvalidLayout, -1},
+		{"count too large", func(r *RTree) { r.count = 6 }, ErrInvalidLayout, -1},
+		{"boxes length", func(r *RTree) { r.boxes = r.boxes[:len(r.boxes)-1] }, ErrInvalidLayout, -1},
+		{"NaN", func(r *RTree) { r.boxes[6] = math.NaN() }, ErrNaNCoordinate, 1},
+		{"first child", func(r *RTree) { r.refs[3] = 4 }, ErrInvalidChildren, 3},
+		{"root end", func(r *RTree) { r.refs[6] = 16 }, ErrInvalidChildren, 5},
+		{"unaligned", func(r *RTree) { r.refs[4] = 6 }, ErrInvalidChildren, 3},
+		{"not monotonic", func(r *RTree) { r.refs[5] = 4 }, ErrInvalidChildren, 4},
+		{"child after parent", func(r *RTree) { r.refs[5] = 20 }, ErrInvalidChildren, 4},
+		{"not contained", func(r *RTree) { r.boxes[14] = 2 }, ErrNotContained, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			index := valid()
+			tc.mutate(index)
+
+			err := index.Validate()
+
+			var verr *ValidationError
+			require.True(t, errors.As(err, &verr))
+			require.True(t, errors.Is(err, tc.err))
+			require.Equal(t, tc.box, verr.Box)
+		})
+	}
+}
+
+func TestDeserializeValidate(t *testing.T) {
+	// refs point past the end of boxes, which panics in Search
+	data, err := proto.Marshal(&internal.RTree{
+		Count: 1,
+		Refs:  []int64{0, 0, 40},
+		Boxes: []int64{0, 0, 1, 1, 0, 0, 1, 1},
+	})
+	require.Nil(t, err)
+
+	_, err = Deserialize(data)
+	require.True(t, errors.Is(err, ErrInvalidChildren))
+	require.Equal(t, "flatrtree: invalid child offsets at box 1", err.Error())
+
+	_, err = DeserializeWithOptions(data, DeserializeOptions{Validate: true})
+	require.True(t, errors.Is(err, ErrInvalidChildren))
+
+	// the root does not contain its item, which only Validate checks
+	data, err = proto.Marshal(&internal.RTree{
+		Count: 1,
+		Refs:  []int64{0, 0, 4},
+		Boxes: []int64{0, 0, 2, 2, 0, 0, 1, 1},
+	})
+	require.Nil(t, err)
+
+	index, err := Deserialize(data)
+	require.Nil(t, err)
+	require.NotNil(t, index)
+
+	_, err = DeserializeWithOptions(data, DeserializeOptions{Validate: true})
+	require.True(t, errors.Is(err, ErrNotContained))
+}
@@ -0,0 +1,232 @@
+package flatrtree
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var _ Index = &RTreeView{}
+
+// RTreeView queries a serialized index in place. Opening a view scans
+// the encoded bytes once to find where values start, but refs and boxes
+// are only decoded when a query visits them.
+//
+// The view references the given bytes, which must not be modified.
+type RTreeView struct {
+	count int
+	scale float64
+	refs  packedVarints
+	boxes packedVarints
+}
+
+// DeserializeLazy returns a view of data produced by Serialize.
+func DeserializeLazy(b []byte) (*RTreeView, error) {
+	var (
+		count     uint64
+		precision uint64
+		refs      []byte
+		boxes     []byte
+		seenRefs  bool
+		seenBoxes bool
+	)
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			count, n = protowire.ConsumeVarint(b)
+		case num == 2 && typ == protowire.BytesType && !seenRefs:
+			refs, n = protowire.ConsumeBytes(b)
+			seenRefs = true
+		case num == 3 && typ == protowire.BytesType && !seenBoxes:
+			boxes, n = protowire.ConsumeBytes(b)
+			seenBoxes = true
+		case num == 4 && typ == protowire.VarintType:
+			precision, n = protowire.ConsumeVarint(b)
+		case num >= 1 && num <= 4:
+			return nil, errors.New("flatrtree: lazy view requires a single packed field for refs and boxes")
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+
+	view := &RTreeView{
+		count: int(uint32(count)),
+		scale: math.Pow10(int(uint32(precision))),
+	}
+
+	var err error
+	if view.refs, err = newPackedVarints(refs); err != nil {
+		return nil, err
+	}
+	if view.boxes, err = newPackedVarints(boxes); err != nil {
+		return nil, err
+	}
+
+	if view.count == 0 {
+		if view.refs.n != 0 || view.boxes.n != 0 {
+			return nil, ErrCorruptNode
+		}
+	} else if view.refs.n < int64(view.count)+2 || view.boxes.n != 4*(view.refs.n-1) {
+		return nil, ErrCorruptNode
+	}
+
+	return view, nil
+}
+
+// Count returns the number of items in the index
+func (v *RTreeView) Count() int {
+	return v.count
+}
+
+// Search calls the iterf function for all items intersecting the
+// search box. If iterf returns false the search will terminate.
+//
+// DeserializeLazy does not decode nodes, so Search panics if it visits
+// a corrupt node. Use SearchErr for data from untrusted sources.
+func (v *RTreeView) Search(
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64) (next bool),
+) {
+	if err := v.SearchErr(minX, minY, maxX, maxY, iterf); err != nil {
+		panic(err)
+	}
+}
+
+// SearchErr is Search, returning an error instead of panicking if
+// it visits a corrupt node.
+func (v *RTreeView) SearchErr(
+	minX, minY, maxX, maxY float64,
+	iterf func(ref int64) (next bool),
+) error {
+	return searchSource(v, minX, minY, maxX, maxY, iterf)
+}
+
+// Neighbors calls the iterf function for all items in ascending order of distance
+// to the given coordinates. If iterf returns false the search will terminate.
+//
+// See RTree.Neighbors for a description of boxDist and itemDist. Like
+// Search, Neighbors panics if it visits a corrupt node; use NeighborsErr
+// for data from untrusted sources.
+func (v *RTreeView) Neighbors(
+	x, y float64,
+	iterf func(ref int64, dist float64) (next bool),
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) {
+	if err := v.NeighborsErr(x, y, iterf, boxDist, itemDist); err != nil {
+		panic(err)
+	}
+}
+
+// NeighborsErr is Neighbors, returning an error instead of panicking
+// if it visits a corrupt node.
+func (v *RTreeView) NeighborsErr(
+	x, y float64,
+	iterf func(ref int64, dist float64) (next bool),
+	boxDist func(pX, pY, minX, minY, maxX, maxY float64) (dist float64),
+	itemDist func(pX, pY float64, ref int64) (dist float64),
+) error {
+	return neighborsSource(v, x, y, iterf, boxDist, itemDist)
+}
+
+func (v *RTreeView) numItems() int64 {
+	return int64(v.count)
+}
+
+func (v *RTreeView) numRefs() int64 {
+	return v.refs.n
+}
+
+func (v *RTreeView) readRefs(dst []int64, start int64) error {
+	if start < 0 || start+int64(len(dst)) > v.refs.n {
+		return ErrCorruptNode
+	}
+
+	pos := v.refs.seek(start)
+	for i := range dst {
+		x, n := protowire.ConsumeVarint(v.refs.data[pos:])
+		dst[i] = int64(x)
+		pos += n
+	}
+
+	return nil
+}
+
+func (v *RTreeView) readBoxes(dst []float64, start int64) error {
+	start *= 4
+	if start < 0 || start+int64(len(dst)) > v.boxes.n {
+		return ErrCorruptNode
+	}
+
+	pos := v.boxes.seek(start)
+	for i := range dst {
+		x, n := protowire.ConsumeVarint(v.boxes.data[pos:])
+		dst[i] = float64(protowire.DecodeZigZag(x)) / v.scale
+		pos += n
+	}
+
+	return nil
+}
+
+// varintStride is the number of values between
+// recorded offsets in packedVarints.
+const varintStride = 16
+
+// packedVarints provides random access to a packed repeated field by
+// recording the byte offset of every varintStride-th value.
+type packedVarints struct {
+	data  []byte
+	n     int64
+	marks []int
+}
+
+func newPackedVarints(data []byte) (packedVarints, error) {
+	p := packedVarints{data: data}
+
+	start := 0
+	for i, c := range data {
+		if c < 0x80 {
+			if i-start == binary.MaxVarintLen64-1 && c > 1 {
+				return packedVarints{}, errors.New("flatrtree: varint overflow")
+			}
+			if p.n%varintStride == 0 {
+				p.marks = append(p.marks, start)
+			}
+			p.n++
+			start = i + 1
+		} else if i-start >= binary.MaxVarintLen64-1 {
+			return packedVarints{}, errors.New("flatrtree: varint overflow")
+		}
+	}
+
+	if start != len(data) {
+		return packedVarints{}, errors.New("flatrtree: truncated packed field")
+	}
+
+	return p, nil
+}
+
+// seek returns the byte offset of value i
+func (p *packedVarints) seek(i int64) int {
+	pos := p.marks[i/varintStride]
+	for k := i % varintStride; k > 0; k-- {
+		for p.data[pos] >= 0x80 {
+			pos++
+		}
+		pos++
+	}
+	return pos
+}
@@ -0,0 +1,186 @@
+package flatrtree
+
+import (
+	"math"
+	"testing"
+
+	"github.com/flatrtree/flatrtree-go/internal"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestViewSearch(t *testing.T) {
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := Serialize(tc.index, 0)
+			require.Nil(t, err)
+
+			view, err := DeserializeLazy(data)
+			require.Nil(t, err)
+			require.Equal(t, tc.index.Count(), view.Count())
+
+			for i := 0; i < tc.count; i++ {
+				var expected, actual []int64
+				tc.index.Search(tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3], func(ref int64) bool {
+					expected = append(expected, ref)
+					return true
+				})
+				view.Search(tc.items[i*4], tc.items[i*4+1], tc.items[i*4+2], tc.items[i*4+3], func(ref int64) bool {
+					actual = append(actual, ref)
+					return true
+				})
+				require.Equal(t, expected, actual)
+			}
+
+			var all []int64
+			view.Search(math.Inf(-1), math.Inf(-1), math.Inf(1), math.Inf(1), func(ref int64) bool {
+				all = append(all, ref)
+				return len(all) < 3
+			})
+			if tc.count < 3 {
+				require.Len(t, all, tc.count)
+			} else {
+				require.Len(t, all, 3)
+			}
+		})
+	}
+}
+
+func TestViewNeighbors(t *testing.T) {
+	itemDist := func(x, y float64, ref int64) float64 {
+		return float64(ref)
+	}
+
+	for _, tc := range createTestCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := Serialize(tc.index, 0)
+			require.Nil(t, err)
+
+			view, err := DeserializeLazy(data)
+			require.Nil(t, err)
+
+			for i := 0; i < tc.count; i++ {
+				midX := (tc.items[i*4] + tc.items[i*4+2]) / 2
+				midY := (tc.items[i*4+1] + tc.items[i*4+3]) / 2
+
+				var expected, actual []int64
+				tc.index.Neighbors(midX, midY, func(ref int64, dist float64) bool {
+					expected = append(expected, ref)
+					return true
+				}, PlanarBoxDist, nil)
+				view.Neighbors(midX, midY, func(ref int64, dist float64) bool {
+					actual = append(actual, ref)
+					return true
+				}, PlanarBoxDist, nil)
+				require.Equal(t, expected, actual)
+
+				expected, actual = nil, nil
+				tc.index.Neighbors(midX, midY, func(ref int64, dist float64) bool {
+					expected = append(expected, ref)
+					return true
+				}, PlanarBoxDist, itemDist)
+				view.Neighbors(midX, midY, func(ref int64, dist float64) bool {
+					actual = append(actual, ref)
+					return true
+				}, PlanarBoxDist, itemDist)
+				require.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestViewCities(t *testing.T) {
+	builder := NewOMTBuilder()
+	for i, city := range cities {
+		builder.Add(int64(i), city.Lon, city.Lat, city.Lon, city.Lat)
+	}
+
+	index, err := builder.Finish(DefaultDegree)
+	require.Nil(t, err)
+
+	data, err := Serialize(index, 5)
+	require.Nil(t, err)
+
+	eager, err := Deserialize(data)
+	require.Nil(t, err)
+
+	view, err := DeserializeLazy(data)
+	require.Nil(t, err)
+
+	for _, city := range cities[:100] {
+		var expected, actual []int64
+		eager.Search(city.Lon-1, city.Lat-1, city.Lon+1, city.Lat+1, func(ref int64) bool {
+			expected = append(expected, ref)
+			return true
+		})
+		view.Search(city.Lon-1, city.Lat-1, city.Lon+1, city.Lat+1, func(ref int64) bool {
+			actual = append(actual, ref)
+			return true
+		})
+		require.Equal(t, expected, actual)
+
+		var expectedDists, actualDists []float64
+		eager.Neighbors(city.Lon, city.Lat, func(ref int64, dist float64) bool {
+			expectedDists = append(expectedDists, dist)
+			return len(expectedDists) < 20
+		}, GeodeticBoxDist, nil)
+		view.Neighbors(city.Lon, city.Lat, func(ref int64, dist float64) bool {
+			actualDists = append(actualDists, dist)
+			return len(actualDists) < 20
+		}, GeodeticBoxDist, nil)
+		require.Equal(t, expectedDists, actualDists)
+	}
+}
+
+func TestViewEmpty(t *testing.T) {
+	view, err := DeserializeLazy([]byte{})
+	require.Nil(t, err)
+	require.Equal(t, 0, view.Count())
+
+	view.Search(math.Inf(-1), math.Inf(-1), math.Inf(1), math.Inf(1), func(ref int64) bool {
+		t.Fatal("unexpected result")
+		return true
+	})
+}
+
+func TestViewCorrupt(t *testing.T) {
+	index, _ := createIndex(t, testBuilders["Hilbert"], 100, DefaultDegree)
+
+	data, err := Serialize(index, 0)
+	require.Nil(t, err)
+
+	// truncated
+	_, err = DeserializeLazy(data[:len(data)/2])
+	require.NotNil(t, err)
+
+	// count disagrees with refs
+	corrupt := append([]byte{}, data...)
+	corrupt[1] = 0x7f
+	_, err = DeserializeLazy(corrupt)
+	require.NotNil(t, err)
+}
+
+func TestViewCorruptNode(t *testing.T) {
+	// refs point past the end of boxes, which is only found by queries
+	data, err := proto.Marshal(&internal.RTree{
+		Count: 1,
+		Refs:  []int64{0, 0, 40},
+		Boxes: []int64{0, 0, 1, 1, 0, 0, 1, 1},
+	})
+	require.Nil(t, err)
+
+	view, err := DeserializeLazy(data)
+	require.Nil(t, err)
+
+	err = view.SearchErr(0, 0, 1, 1, func(ref int64) bool { return true })
+	require.ErrorIs(t, err, ErrCorruptNode)
+	require.Panics(t, func() {
+		view.Search(0, 0, 1, 1, func(ref int64) bool { return true })
+	})
+
+	err = view.NeighborsErr(0, 0, func(ref int64, dist float64) bool { return true }, PlanarBoxDist, nil)
+	require.ErrorIs(t, err, ErrCorruptNode)
+	require.Panics(t, func() {
+		view.Neighbors(0, 0, func(ref int64, dist float64) bool { return true }, PlanarBoxDist, nil)
+	})
+}